@@ -3,43 +3,137 @@ package adopt
 import (
 	"crypto/rand"
 	"encoding/hex"
+	"errors"
+	"net"
+	"strings"
+	"time"
 
 	"golang.org/x/crypto/ssh"
 )
 
+// Timeouts for SSH operations, so a device which stops responding does not block the caller forever.
+const (
+	dialTimeout = 10 * time.Second
+	runTimeout  = time.Minute // Covers the handshake and the command, once connected.
+)
+
+// ErrHostKeyMismatch is returned if a device presents a SSH host key which does not match the pinned key.
+var ErrHostKeyMismatch = errors.New("host key does not match pinned key")
+
 // Config specifies all the information to perform an adopt operation.
 type Config struct {
-	APAddr         string
-	ControllerAddr string
+	APAddr         string // host:port of the device's SSH server, IPv6 hosts in brackets.
+	ControllerAddr string // host:port the device informs to, IPv6 hosts in brackets.
+	User           string
 	Pass           string
 
+	// HostKey is the pinned SSH host key of the device, in authorized_keys format. If empty,
+	// the key presented by the device is trusted and stored here (trust on first use).
+	HostKey string
+
 	Key []byte
 }
 
+// HostKeyCallback returns a callback which verifies the host key presented by a device against
+// the pinned key. If no key is pinned, the presented key is trusted and pinned. If pinned is nil,
+// the presented key is trusted without being stored.
+func HostKeyCallback(pinned *string) ssh.HostKeyCallback {
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		presented := strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key)))
+		if pinned == nil {
+			return nil
+		}
+		if *pinned == "" {
+			*pinned = presented
+			return nil
+		}
+		if *pinned != presented {
+			return ErrHostKeyMismatch
+		}
+		return nil
+	}
+}
+
+// ClientConfig returns the SSH configuration to login to a device with the given credentials.
+func ClientConfig(user, pass string, hostKey *string) *ssh.ClientConfig {
+	c := &ssh.ClientConfig{User: user, HostKeyCallback: HostKeyCallback(hostKey), Timeout: dialTimeout}
+	c.Auth = append(c.Auth, ssh.Password(pass))
+	return c
+}
+
 // Adopt performs an adopt operation.
 func Adopt(cfg *Config) error {
-	c := &ssh.ClientConfig{User: "ubnt", HostKeyCallback: ssh.InsecureIgnoreHostKey()}
-	c.Auth = append(c.Auth, ssh.Password(cfg.Pass))
+	_, err := run(cfg, "/usr/bin/syswrapper.sh set-adopt http://"+cfg.ControllerAddr+"/inform "+hex.EncodeToString(cfg.Key))
+	return err
+}
+
+// RestoreDefault resets a device to its factory-default configuration. The device reboots
+// once reset, so may close the connection before the command completes.
+func RestoreDefault(cfg *Config) error {
+	_, err := run(cfg, "/usr/bin/syswrapper.sh restore-default")
+	if _, disconnected := err.(*ssh.ExitMissingError); disconnected {
+		return nil
+	}
+	return err
+}
 
-	client, err := ssh.Dial("tcp", cfg.APAddr, c)
+// DeviceMAC logs into the device and returns the MAC address of its ethernet interface.
+func DeviceMAC(cfg *Config) ([6]byte, error) {
+	var mac [6]byte
+	out, err := run(cfg, "cat /sys/class/net/eth0/address")
 	if err != nil {
-		return err
+		return mac, err
 	}
-	defer client.Close()
+	hw, err := net.ParseMAC(strings.TrimSpace(string(out)))
+	if err != nil {
+		return mac, err
+	}
+	if len(hw) != 6 {
+		return mac, errors.New("unexpected MAC address " + hw.String())
+	}
+	copy(mac[:], hw)
+	return mac, nil
+}
 
-	s, err := client.NewSession()
+// DHCPOption43 returns the hex-encoded value of DHCP option 43 (vendor specific information) which
+// points devices at the controller. Devices receiving this option inform to http://<ip>:8080/inform.
+func DHCPOption43(controllerIP net.IP) (string, error) {
+	ip := controllerIP.To4()
+	if ip == nil {
+		return "", errors.New("DHCP option 43 requires an IPv4 address")
+	}
+	return hex.EncodeToString(append([]byte{0x01, 0x04}, ip...)), nil
+}
+
+// run logs into the device and runs the given command, returning its output.
+func run(cfg *Config, cmd string) ([]byte, error) {
+	c := ClientConfig(cfg.User, cfg.Pass, &cfg.HostKey)
+
+	conn, err := net.DialTimeout("tcp", cfg.APAddr, c.Timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if err := conn.SetDeadline(time.Now().Add(runTimeout)); err != nil {
+		return nil, err
+	}
+	sshConn, chans, reqs, err := ssh.NewClientConn(conn, cfg.APAddr, c)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	client := ssh.NewClient(sshConn, chans, reqs)
+	defer client.Close()
 
-	_, err = s.CombinedOutput("/usr/bin/syswrapper.sh set-adopt http://" + cfg.ControllerAddr + "/inform " + hex.EncodeToString(cfg.Key))
+	s, err := client.NewSession()
 	if err != nil {
-		return err
+		return nil, err
 	}
-	return nil
+	defer s.Close()
+
+	return s.CombinedOutput(cmd)
 }
 
-// NewConfig creates a Config with a random encryption key.
+// NewConfig creates a Config with a random encryption key, which logs in as the default ubnt user.
 func NewConfig(apAddr, controllerAddr, pass string) *Config {
 	b, err := GenerateRandomBytes(16)
 	if err != nil {
@@ -49,6 +143,7 @@ func NewConfig(apAddr, controllerAddr, pass string) *Config {
 		APAddr:         apAddr,
 		ControllerAddr: controllerAddr,
 		Key:            b,
+		User:           "ubnt",
 		Pass:           pass,
 	}
 }
@@ -0,0 +1,64 @@
+package adopt
+
+import (
+	"crypto/rand"
+	"net"
+	"testing"
+
+	"golang.org/x/crypto/ed25519"
+	"golang.org/x/crypto/ssh"
+)
+
+func newHostKey(t *testing.T) ssh.PublicKey {
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	k, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return k
+}
+
+func TestHostKeyCallbackTOFU(t *testing.T) {
+	first, second := newHostKey(t), newHostKey(t)
+
+	var pinned string
+	cb := HostKeyCallback(&pinned)
+	if err := cb("ap:22", nil, first); err != nil {
+		t.Fatalf("Expected first key to be trusted, got %v", err)
+	}
+	if pinned == "" {
+		t.Fatal("Expected key to be pinned")
+	}
+	if err := cb("ap:22", nil, first); err != nil {
+		t.Errorf("Expected pinned key to be accepted, got %v", err)
+	}
+	if err := cb("ap:22", nil, second); err != ErrHostKeyMismatch {
+		t.Errorf("Expected ErrHostKeyMismatch, got %v", err)
+	}
+
+	// Re-pinning
+	pinned = ""
+	if err := HostKeyCallback(&pinned)("ap:22", nil, second); err != nil {
+		t.Errorf("Expected new key to be trusted after re-pin, got %v", err)
+	}
+
+	if err := HostKeyCallback(nil)("ap:22", nil, first); err != nil {
+		t.Errorf("Expected key to be trusted without pinning, got %v", err)
+	}
+}
+
+func TestDHCPOption43(t *testing.T) {
+	out, err := DHCPOption43(net.ParseIP("192.168.1.2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != "0104c0a80102" {
+		t.Errorf("Expected 0104c0a80102, got %s", out)
+	}
+	if _, err := DHCPOption43(net.ParseIP("fd00::1")); err == nil {
+		t.Error("Expected error for IPv6 address")
+	}
+}
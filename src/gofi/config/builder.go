@@ -2,6 +2,7 @@ package config
 
 import (
 	"errors"
+	"net"
 	"strconv"
 	"strings"
 )
@@ -12,18 +13,36 @@ const (
 	WpaEapRadius = 1
 )
 
+// Bands a network can be broadcast on
+const (
+	Band2G   = 0
+	Band5G   = 1
+	BandBoth = 2
+)
+
 // Network represents configuration for a wireless SSID.
 type Network struct {
 	Kind     int
 	SSID     string
 	Pass     string
-	Is5Ghz   bool
+	Band     int
 	NoBeacon bool
 	Channel  int
 
 	RadiusIP     string
 	RadiusPort   int
 	RadiusSecret string
+
+	DTIMPeriod       int  // 0 = firmware default
+	BeaconInterval   int  // In TUs (1.024ms), 0 = firmware default
+	MulticastEnhance bool // Convert multicast traffic to unicast for each station
+	ProxyARP         bool // Answer ARP requests on behalf of stations
+
+	// Bandwidth limits in Kbps, 0 = unlimited. If RateLimitPerClient is set, the limits apply
+	// to each station individually, otherwise they are shared by all stations on the SSID.
+	RateLimitUpKbps    int
+	RateLimitDownKbps  int
+	RateLimitPerClient bool
 }
 
 // band steering modes
@@ -42,6 +61,15 @@ type SteerSettings struct {
 type SwitchSettings struct {
 }
 
+// RadioSettings specifies options which apply to a single radio.
+type RadioSettings struct {
+	Channel         int // 0 = auto
+	AirtimeFairness bool
+	// MinBasicRateKbps is the lowest data rate stations may use. On the 2.4Ghz radio,
+	// 802.11b (CCK) rates are disabled if this is 6Mbps or higher. 0 = firmware default.
+	MinBasicRateKbps int
+}
+
 // Config stores logical configuration of the network.
 type Config struct {
 	Networks        []Network
@@ -50,7 +78,22 @@ type Config struct {
 	MinRSSI         int
 	MinRSSIInterval int
 
+	Radio2G RadioSettings
+	Radio5G RadioSettings
+
 	SwitchConfig SwitchSettings
+
+	Alias string // Human readable name of the device, which is also used as its hostname.
+	LED   LEDSettings
+
+	// Admin credentials for the device. If AdminPasswordHash is empty, the device keeps the credentials
+	// in the base configuration (ubnt/ubnt on APs).
+	AdminUser         string   `json:",omitempty"` // Defaults to ubnt.
+	AdminPasswordHash string   `json:"-"`          // crypt(3) hash, see HashPassword().
+	AuthorizedKeys    []string `json:",omitempty"` // SSH public keys, in authorized_keys format.
+
+	// Patches are raw changes applied to the generated system configuration.
+	Patches []Patch `json:",omitempty"`
 }
 
 var baseTwoRadioDevice = `
@@ -209,21 +252,75 @@ wireless.XREPX.puren=0
 wireless.XREPX.pureg=1
 `
 
-// GenerateSysConf ingests the devices current config and modifies it based on the fields in Config.
-func (b *Config) GenerateSysConf(modelName, configVersion string) (string, error) {
-	var conf *Section
-	var err error
+// vap represents a network on a single radio.
+type vap struct {
+	Network
+	is5Ghz bool
+}
+
+// vaps expands the configured networks into one virtual AP per radio.
+func (b *Config) vaps() []vap {
+	var out []vap
+	for _, net := range b.Networks {
+		if net.Band == Band2G || net.Band == BandBoth {
+			out = append(out, vap{Network: net})
+		}
+		if net.Band == Band5G || net.Band == BandBoth {
+			out = append(out, vap{Network: net, is5Ghz: true})
+		}
+	}
+	return out
+}
 
+// Validate returns an error if the configuration is inconsistent, such as enabling band
+// steering without any networks on both bands.
+func (b *Config) Validate() error {
+	if err := b.validateNetworks(); err != nil {
+		return err
+	}
+
+	if b.Bandsteer.Enabled {
+		canSteer := false
+		for _, net := range b.Networks {
+			if net.Band == BandBoth {
+				canSteer = true
+			}
+		}
+		if !canSteer {
+			return errors.New("Cannot bandsteer without a network on both bands")
+		}
+	}
+	return nil
+}
+
+// validateNetworks returns an error if the networks cannot be applied to a device.
+func (b *Config) validateNetworks() error {
 	if len(b.Networks) == 0 {
-		return "", errors.New("At least one network must be specified")
+		return errors.New("At least one network must be specified")
+	}
+	for _, net := range b.Networks {
+		if net.Band != Band2G && net.Band != Band5G && net.Band != BandBoth {
+			return errors.New("invalid band for network " + net.SSID)
+		}
 	}
 
-	if len(b.Networks) > 2 {
-		return "", errors.New("we do not currently support more than 2 networks")
+	if len(b.vaps()) > 2 {
+		return errors.New("we do not currently support more than 2 networks across both radios")
 		// To do that, we have to implement all of this nonsense
 		// # radio.2.virtual.1.devname=ath2
 		// # radio.2.virtual.1.status=enabled
 	}
+	return nil
+}
+
+// GenerateSysConf ingests the devices current config and modifies it based on the fields in Config.
+func (b *Config) GenerateSysConf(modelName, configVersion string) (string, error) {
+	var conf *Section
+	var err error
+
+	if err = b.Validate(); err != nil {
+		return "", err
+	}
 
 	switch modelName {
 	case "USW-8P-60":
@@ -249,6 +346,12 @@ func (b *Config) GenerateSysConf(modelName, configVersion string) (string, error
 			return "", err
 		}
 	}
+	if err = b.applyDeviceConf(conf); err != nil {
+		return "", err
+	}
+	if err = b.applyPatches(conf, modelName); err != nil {
+		return "", err
+	}
 
 	var newSysConf string
 	newSysConf, err = conf.Serialize()
@@ -259,7 +362,29 @@ func (b *Config) GenerateSysConf(modelName, configVersion string) (string, error
 	return newSysConf, err
 }
 
+// ControllerAddr returns the host:port APs connect to for a controller at localAddr, listening on
+// listenerAddr (ie: ":8421"). IPv6 addresses are enclosed in brackets.
+func ControllerAddr(localAddr, listenerAddr string) string {
+	_, port, err := net.SplitHostPort(listenerAddr)
+	if err != nil {
+		port = strings.TrimPrefix(listenerAddr, ":")
+	}
+	return net.JoinHostPort(strings.Trim(localAddr, "[]"), port)
+}
+
+// InformURL returns the URL APs inform to for a controller at localAddr, listening on listenerAddr.
+func InformURL(localAddr, listenerAddr string) string {
+	return "http://" + ControllerAddr(localAddr, listenerAddr) + "/inform"
+}
+
+// GenerateMgmtConf generates the management configuration for an AP, which informs to the controller
+// at localAddr, listening on listenerAddr.
 func (b *Config) GenerateMgmtConf(auth, configVersion, localAddr, listenerAddr string) (string, error) {
+	return b.GenerateMgmtConfURL(auth, configVersion, InformURL(localAddr, listenerAddr))
+}
+
+// GenerateMgmtConfURL generates the management configuration for an AP, which informs to informURL.
+func (b *Config) GenerateMgmtConfURL(auth, configVersion, informURL string) (string, error) {
 	configMgmt, err := Parse([]byte(`
 		mgmt.is_default=false
 		mgmt.authkey=41d6529fd555fbb1bdeeafeb995510fa
@@ -274,17 +399,18 @@ func (b *Config) GenerateMgmtConf(auth, configVersion, localAddr, listenerAddr s
 	if err != nil {
 		return "", err
 	}
-	configMgmt.Get("mgmt").Get("servers").Get("1").Get("url").SetVal("http://" + localAddr + listenerAddr + "/inform")
+	configMgmt.Get("mgmt").Get("servers").Get("1").Get("url").SetVal(informURL)
 	configMgmt.Get("mgmt").Get("authkey").SetVal(auth)
 	configMgmt.Get("authkey").SetVal(auth)
 	configMgmt.Get("mgmt").Get("cfgversion").SetVal(configVersion)
 	configMgmt.Get("cfgversion").SetVal(configVersion)
+	configMgmt.Get("led_enabled").SetVal(strconv.FormatBool(b.LED.EnabledAt(now())))
 	return configMgmt.Serialize()
 }
 
 func (b *Config) applySysConf(config *Section, configVersion string) error {
 
-	for i, net := range b.Networks {
+	for i, net := range b.vaps() {
 		index := strconv.Itoa(i + 1)
 		base := strings.Replace(perNetworkBase, "XREPX", index, -1)
 		netSpecific, err := Parse([]byte(base))
@@ -307,7 +433,7 @@ func (b *Config) applySysConf(config *Section, configVersion string) error {
 			netSpecific.Get("wireless").Get(index).Get("hide_ssid").SetVal("false")
 		}
 
-		if net.Is5Ghz {
+		if net.is5Ghz {
 			netSpecific.Get("wireless").Get(index).Get("parent").SetVal("wifi1")
 		} else {
 			netSpecific.Get("wireless").Get(index).Get("parent").SetVal("wifi0")
@@ -316,6 +442,22 @@ func (b *Config) applySysConf(config *Section, configVersion string) error {
 			netSpecific.Get("wireless").Get(index).Get("channel").SetVal(strconv.Itoa(net.Channel))
 		}
 
+		if net.DTIMPeriod != 0 {
+			netSpecific.Get("wireless").Get(index).Get("dtim_period").SetVal(strconv.Itoa(net.DTIMPeriod))
+		}
+		if net.BeaconInterval != 0 {
+			netSpecific.Get("wireless").Get(index).Get("bintval").SetVal(strconv.Itoa(net.BeaconInterval))
+		}
+		if net.MulticastEnhance {
+			netSpecific.Get("wireless").Get(index).Get("mcastenhance").SetVal("enabled")
+		}
+		if net.ProxyARP {
+			netSpecific.Get("wireless").Get(index).Get("proxy_arp").SetVal("enabled")
+		}
+		if net.RateLimitUpKbps != 0 || net.RateLimitDownKbps != 0 {
+			applyRateLimit(netSpecific.Get("wireless").Get(index).Get("ratelimit"), &net.Network)
+		}
+
 		switch net.Kind {
 		case WpaEapRadius:
 			netSpecific.Get("aaa").Get(index).Get("radius").Get("acct").Get("1").Get("ip").SetVal(net.RadiusIP)
@@ -342,6 +484,9 @@ func (b *Config) applySysConf(config *Section, configVersion string) error {
 		}
 	}
 
+	applyRadioConf(config, "1", &b.Radio2G, true)
+	applyRadioConf(config, "2", &b.Radio5G, false)
+
 	if b.Txpower != 0 {
 		config.Get("radio").Get("1").Get("txpower").SetVal(strconv.Itoa(b.Txpower))
 		config.Get("radio").Get("2").Get("txpower").SetVal(strconv.Itoa(b.Txpower))
@@ -375,3 +520,40 @@ func (b *Config) applySysConf(config *Section, configVersion string) error {
 
 	return nil
 }
+
+// applyRadioConf sets per-radio options on the radio with the given index.
+func applyRadioConf(config *Section, index string, r *RadioSettings, is2G bool) {
+	radio := config.Get("radio").Get(index)
+	if r.Channel != 0 {
+		radio.Get("channel").SetVal(strconv.Itoa(r.Channel))
+	}
+
+	if r.AirtimeFairness {
+		config.Get("atf").Get("status").SetVal("enabled")
+		radio.Get("atf").Get("status").SetVal("enabled")
+	}
+
+	if r.MinBasicRateKbps != 0 {
+		radio.Get("minrate").Get("status").SetVal("enabled")
+		radio.Get("minrate").Get("rate").SetVal(strconv.Itoa(r.MinBasicRateKbps))
+		if is2G && r.MinBasicRateKbps >= 6000 {
+			radio.Get("cck").Get("status").SetVal("disabled")
+		}
+	}
+}
+
+// applyRateLimit sets the bandwidth limits of a network on the given ratelimit section.
+func applyRateLimit(rl *Section, net *Network) {
+	rl.Get("status").SetVal("enabled")
+	if net.RateLimitUpKbps != 0 {
+		rl.Get("up").SetVal(strconv.Itoa(net.RateLimitUpKbps))
+	}
+	if net.RateLimitDownKbps != 0 {
+		rl.Get("down").SetVal(strconv.Itoa(net.RateLimitDownKbps))
+	}
+	if net.RateLimitPerClient {
+		rl.Get("mode").SetVal("per_sta")
+	} else {
+		rl.Get("mode").SetVal("shared")
+	}
+}
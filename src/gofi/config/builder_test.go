@@ -1,6 +1,7 @@
 package config
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/kylelemons/godebug/diff"
@@ -18,6 +19,18 @@ aaa.1.wpa.group_rekey=0
 aaa.1.wpa.key.1.mgmt=WPA-PSK
 aaa.1.wpa.psk=the_shrekkening
 aaa.1.wpa=2
+aaa.2.br.devname=br0
+aaa.2.devname=ath1
+aaa.2.driver=madwifi
+aaa.2.eapol_version=2
+aaa.2.ssid=kek
+aaa.2.status=enabled
+aaa.2.verbose=2
+aaa.2.wpa.1.pairwise=CCMP
+aaa.2.wpa.group_rekey=0
+aaa.2.wpa.key.1.mgmt=WPA-PSK
+aaa.2.wpa.psk=the_shrekkening
+aaa.2.wpa=2
 aaa.status=enabled
 bandsteering.mode=equal
 bandsteering.status=enabled
@@ -25,6 +38,7 @@ bridge.1.devname=br0
 bridge.1.fd=1
 bridge.1.port.1.devname=eth0
 bridge.1.port.2.devname=ath0
+bridge.1.port.3.devname=ath1
 bridge.1.stp.status=disabled
 bridge.status=enabled
 dhcpc.1.devname=br0
@@ -139,6 +153,29 @@ wireless.1.vport=disabled
 wireless.1.vwire=disabled
 wireless.1.wds=disabled
 wireless.1.wmm=enabled
+wireless.2.addmtikie=disabled
+wireless.2.authmode=1
+wireless.2.autowds=disabled
+wireless.2.devname=ath1
+wireless.2.hide_ssid=false
+wireless.2.is_guest=false
+wireless.2.l2_isolation=disabled
+wireless.2.mac_acl.policy=deny
+wireless.2.mac_acl.status=enabled
+wireless.2.mode=master
+wireless.2.parent=wifi1
+wireless.2.pureg=1
+wireless.2.puren=0
+wireless.2.schedule_enabled=disabled
+wireless.2.security=none
+wireless.2.ssid=kek
+wireless.2.status=enabled
+wireless.2.uapsd=disabled
+wireless.2.usage=user
+wireless.2.vport=disabled
+wireless.2.vwire=disabled
+wireless.2.wds=disabled
+wireless.2.wmm=enabled
 wireless.status=enabled`
 
 func TestBuildACLR(t *testing.T) {
@@ -147,6 +184,7 @@ func TestBuildACLR(t *testing.T) {
 			Network{
 				SSID: "kek",
 				Pass: "the_shrekkening",
+				Band: BandBoth,
 			},
 		},
 		Bandsteer: SteerSettings{
@@ -183,8 +221,8 @@ aaa.1.wpa.key.1.mgmt=WPA-EAP
 aaa.1.wpa.psk=the_shrekkening
 aaa.1.wpa=2
 aaa.status=enabled
-bandsteering.mode=equal
-bandsteering.status=enabled
+bandsteering.mode=prefer_5g
+bandsteering.status=disabled
 bridge.1.devname=br0
 bridge.1.fd=1
 bridge.1.port.1.devname=eth0
@@ -317,10 +355,6 @@ func TestBuildACLRRadius(t *testing.T) {
 				RadiusSecret: "secret",
 			},
 		},
-		Bandsteer: SteerSettings{
-			Enabled: true,
-			Mode:    SteerBalance,
-		},
 	}
 	out, err := c.GenerateSysConf("UAP-AC-LR", "123") //Make modifications based on desired settings
 	if err != nil {
@@ -345,8 +379,8 @@ aaa.1.wpa.key.1.mgmt=WPA-PSK
 aaa.1.wpa.psk=the_shrekkening
 aaa.1.wpa=2
 aaa.status=enabled
-bandsteering.mode=equal
-bandsteering.status=enabled
+bandsteering.mode=prefer_5g
+bandsteering.status=disabled
 bridge.1.devname=br0
 bridge.1.fd=1
 bridge.1.port.1.devname=eth0
@@ -475,10 +509,6 @@ func TestBuildACLRTxPower(t *testing.T) {
 				Pass: "the_shrekkening",
 			},
 		},
-		Bandsteer: SteerSettings{
-			Enabled: true,
-			Mode:    SteerBalance,
-		},
 		Txpower: 16,
 	}
 	out, err := c.GenerateSysConf("UAP-AC-LR", "123") //Make modifications based on desired settings
@@ -504,8 +534,8 @@ aaa.1.wpa.key.1.mgmt=WPA-PSK
 aaa.1.wpa.psk=the_shrekkening
 aaa.1.wpa=2
 aaa.status=enabled
-bandsteering.mode=equal
-bandsteering.status=enabled
+bandsteering.mode=prefer_5g
+bandsteering.status=disabled
 bridge.1.devname=br0
 bridge.1.fd=1
 bridge.1.port.1.devname=eth0
@@ -648,10 +678,6 @@ func TestBuildACLRMinRSSI(t *testing.T) {
 				Pass: "the_shrekkening",
 			},
 		},
-		Bandsteer: SteerSettings{
-			Enabled: true,
-			Mode:    SteerBalance,
-		},
 		MinRSSI:         70,
 		MinRSSIInterval: 1,
 	}
@@ -664,3 +690,491 @@ func TestBuildACLRMinRSSI(t *testing.T) {
 		t.Error("Output mismatch")
 	}
 }
+
+var expectedDenseTuning = `aaa.1.br.devname=br0
+aaa.1.devname=ath0
+aaa.1.driver=madwifi
+aaa.1.eapol_version=2
+aaa.1.ssid=kek
+aaa.1.status=enabled
+aaa.1.verbose=2
+aaa.1.wpa.1.pairwise=CCMP
+aaa.1.wpa.group_rekey=0
+aaa.1.wpa.key.1.mgmt=WPA-PSK
+aaa.1.wpa.psk=the_shrekkening
+aaa.1.wpa=2
+aaa.status=enabled
+atf.status=enabled
+bandsteering.mode=prefer_5g
+bandsteering.status=disabled
+bridge.1.devname=br0
+bridge.1.fd=1
+bridge.1.port.1.devname=eth0
+bridge.1.port.2.devname=ath0
+bridge.1.stp.status=disabled
+bridge.status=enabled
+dhcpc.1.devname=br0
+dhcpc.1.status=enabled
+dhcpc.status=enabled
+dhcpd.1.status=disabled
+dhcpd.status=disabled
+ebtables.1.cmd=-t broute -A BROUTING -p 0x888e -i ath0 -j DROP
+ebtables.status=enabled
+httpd.status=disabled
+mgmt.discovery.status=enabled
+mgmt.flavor=ace
+mgmt.is_default=true
+netconf.1.autoip.status=disabled
+netconf.1.devname=br0
+netconf.1.ip=192.168.1.20
+netconf.1.netmask=255.255.255.0
+netconf.1.status=enabled
+netconf.1.up=enabled
+netconf.2.autoip.status=disabled
+netconf.2.devname=eth0
+netconf.2.ip=0.0.0.0
+netconf.2.promisc=enabled
+netconf.2.status=enabled
+netconf.2.up=enabled
+netconf.3.autoip.status=disabled
+netconf.3.devname=ath0
+netconf.3.ip=0.0.0.0
+netconf.3.promisc=enabled
+netconf.3.status=enabled
+netconf.3.up=disabled
+netconf.4.autoip.status=disabled
+netconf.4.devname=ath1
+netconf.4.ip=0.0.0.0
+netconf.4.promisc=enabled
+netconf.4.status=enabled
+netconf.4.up=disabled
+netconf.status=enabled
+ntpclient.1.server=0.ubnt.pool.ntp.org
+ntpclient.1.status=enabled
+ntpclient.status=enabled
+radio.1.ack.auto=disabled
+radio.1.acktimeout=64
+radio.1.ampdu.status=enabled
+radio.1.atf.status=enabled
+radio.1.bgscan.status=disabled
+radio.1.cck.status=disabled
+radio.1.channel=auto
+radio.1.cwm.enable=0
+radio.1.cwm.mode=0
+radio.1.devname=ath0
+radio.1.forbiasauto=0
+radio.1.hard_noisefloor.status=disabled
+radio.1.ieee_mode=11nght20
+radio.1.minrate.rate=12000
+radio.1.minrate.status=enabled
+radio.1.mode=master
+radio.1.phyname=wifi0
+radio.1.rate.auto=enabled
+radio.1.rate.mcs=auto
+radio.1.status=enabled
+radio.1.txpower=auto
+radio.1.txpower_mode=auto
+radio.1.ubntroam.status=disabled
+radio.2.ack.auto=disabled
+radio.2.acktimeout=64
+radio.2.ampdu.status=enabled
+radio.2.bgscan.status=disabled
+radio.2.channel=auto
+radio.2.clksel=1
+radio.2.cwm.enable=0
+radio.2.cwm.mode=1
+radio.2.devname=ath1
+radio.2.forbiasauto=0
+radio.2.hard_noisefloor.status=disabled
+radio.2.ieee_mode=11naht40
+radio.2.minrate.rate=24000
+radio.2.minrate.status=enabled
+radio.2.mode=master
+radio.2.phyname=wifi1
+radio.2.rate.auto=enabled
+radio.2.rate.mcs=auto
+radio.2.status=enabled
+radio.2.txpower=auto
+radio.2.txpower_mode=auto
+radio.2.ubntroam.status=disabled
+radio.countrycode=36
+radio.status=enabled
+route.status=enabled
+syslog.file=/var/log/messages
+syslog.level=8
+syslog.remote.ip=192.168.1.1
+syslog.remote.port=514
+syslog.remote.status=disabled
+syslog.rotate=1
+syslog.size=200
+syslog.status=enabled
+wireless.1.addmtikie=disabled
+wireless.1.authmode=1
+wireless.1.autowds=disabled
+wireless.1.bintval=200
+wireless.1.devname=ath0
+wireless.1.dtim_period=3
+wireless.1.hide_ssid=false
+wireless.1.is_guest=false
+wireless.1.l2_isolation=disabled
+wireless.1.mac_acl.policy=deny
+wireless.1.mac_acl.status=enabled
+wireless.1.mcastenhance=enabled
+wireless.1.mode=master
+wireless.1.parent=wifi0
+wireless.1.proxy_arp=enabled
+wireless.1.pureg=1
+wireless.1.puren=0
+wireless.1.schedule_enabled=disabled
+wireless.1.security=none
+wireless.1.ssid=kek
+wireless.1.status=enabled
+wireless.1.uapsd=disabled
+wireless.1.usage=user
+wireless.1.vport=disabled
+wireless.1.vwire=disabled
+wireless.1.wds=disabled
+wireless.1.wmm=enabled
+wireless.status=enabled`
+
+func TestBuildACLRDenseTuning(t *testing.T) {
+	c := Config{
+		Networks: []Network{
+			Network{
+				SSID:             "kek",
+				Pass:             "the_shrekkening",
+				DTIMPeriod:       3,
+				BeaconInterval:   200,
+				MulticastEnhance: true,
+				ProxyARP:         true,
+			},
+		},
+		Radio2G: RadioSettings{
+			AirtimeFairness:  true,
+			MinBasicRateKbps: 12000,
+		},
+		Radio5G: RadioSettings{
+			MinBasicRateKbps: 24000,
+		},
+	}
+	out, err := c.GenerateSysConf("UAP-AC-LR", "123")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != expectedDenseTuning {
+		t.Log(diff.Diff(expectedDenseTuning, out))
+		t.Error("Output mismatch")
+	}
+}
+
+var expectedRateLimit = `aaa.1.br.devname=br0
+aaa.1.devname=ath0
+aaa.1.driver=madwifi
+aaa.1.eapol_version=2
+aaa.1.ssid=kek
+aaa.1.status=enabled
+aaa.1.verbose=2
+aaa.1.wpa.1.pairwise=CCMP
+aaa.1.wpa.group_rekey=0
+aaa.1.wpa.key.1.mgmt=WPA-PSK
+aaa.1.wpa.psk=the_shrekkening
+aaa.1.wpa=2
+aaa.2.br.devname=br0
+aaa.2.devname=ath1
+aaa.2.driver=madwifi
+aaa.2.eapol_version=2
+aaa.2.ssid=guest
+aaa.2.status=enabled
+aaa.2.verbose=2
+aaa.2.wpa.1.pairwise=CCMP
+aaa.2.wpa.group_rekey=0
+aaa.2.wpa.key.1.mgmt=WPA-PSK
+aaa.2.wpa.psk=guest
+aaa.2.wpa=2
+aaa.status=enabled
+bandsteering.mode=prefer_5g
+bandsteering.status=disabled
+bridge.1.devname=br0
+bridge.1.fd=1
+bridge.1.port.1.devname=eth0
+bridge.1.port.2.devname=ath0
+bridge.1.port.3.devname=ath1
+bridge.1.stp.status=disabled
+bridge.status=enabled
+dhcpc.1.devname=br0
+dhcpc.1.status=enabled
+dhcpc.status=enabled
+dhcpd.1.status=disabled
+dhcpd.status=disabled
+ebtables.1.cmd=-t broute -A BROUTING -p 0x888e -i ath0 -j DROP
+ebtables.status=enabled
+httpd.status=disabled
+mgmt.discovery.status=enabled
+mgmt.flavor=ace
+mgmt.is_default=true
+netconf.1.autoip.status=disabled
+netconf.1.devname=br0
+netconf.1.ip=192.168.1.20
+netconf.1.netmask=255.255.255.0
+netconf.1.status=enabled
+netconf.1.up=enabled
+netconf.2.autoip.status=disabled
+netconf.2.devname=eth0
+netconf.2.ip=0.0.0.0
+netconf.2.promisc=enabled
+netconf.2.status=enabled
+netconf.2.up=enabled
+netconf.3.autoip.status=disabled
+netconf.3.devname=ath0
+netconf.3.ip=0.0.0.0
+netconf.3.promisc=enabled
+netconf.3.status=enabled
+netconf.3.up=disabled
+netconf.4.autoip.status=disabled
+netconf.4.devname=ath1
+netconf.4.ip=0.0.0.0
+netconf.4.promisc=enabled
+netconf.4.status=enabled
+netconf.4.up=disabled
+netconf.status=enabled
+ntpclient.1.server=0.ubnt.pool.ntp.org
+ntpclient.1.status=enabled
+ntpclient.status=enabled
+radio.1.ack.auto=disabled
+radio.1.acktimeout=64
+radio.1.ampdu.status=enabled
+radio.1.bgscan.status=disabled
+radio.1.channel=auto
+radio.1.cwm.enable=0
+radio.1.cwm.mode=0
+radio.1.devname=ath0
+radio.1.forbiasauto=0
+radio.1.hard_noisefloor.status=disabled
+radio.1.ieee_mode=11nght20
+radio.1.mode=master
+radio.1.phyname=wifi0
+radio.1.rate.auto=enabled
+radio.1.rate.mcs=auto
+radio.1.status=enabled
+radio.1.txpower=auto
+radio.1.txpower_mode=auto
+radio.1.ubntroam.status=disabled
+radio.2.ack.auto=disabled
+radio.2.acktimeout=64
+radio.2.ampdu.status=enabled
+radio.2.bgscan.status=disabled
+radio.2.channel=auto
+radio.2.clksel=1
+radio.2.cwm.enable=0
+radio.2.cwm.mode=1
+radio.2.devname=ath1
+radio.2.forbiasauto=0
+radio.2.hard_noisefloor.status=disabled
+radio.2.ieee_mode=11naht40
+radio.2.mode=master
+radio.2.phyname=wifi1
+radio.2.rate.auto=enabled
+radio.2.rate.mcs=auto
+radio.2.status=enabled
+radio.2.txpower=auto
+radio.2.txpower_mode=auto
+radio.2.ubntroam.status=disabled
+radio.countrycode=36
+radio.status=enabled
+route.status=enabled
+syslog.file=/var/log/messages
+syslog.level=8
+syslog.remote.ip=192.168.1.1
+syslog.remote.port=514
+syslog.remote.status=disabled
+syslog.rotate=1
+syslog.size=200
+syslog.status=enabled
+wireless.1.addmtikie=disabled
+wireless.1.authmode=1
+wireless.1.autowds=disabled
+wireless.1.devname=ath0
+wireless.1.hide_ssid=false
+wireless.1.is_guest=false
+wireless.1.l2_isolation=disabled
+wireless.1.mac_acl.policy=deny
+wireless.1.mac_acl.status=enabled
+wireless.1.mode=master
+wireless.1.parent=wifi0
+wireless.1.pureg=1
+wireless.1.puren=0
+wireless.1.ratelimit.down=8192
+wireless.1.ratelimit.mode=shared
+wireless.1.ratelimit.status=enabled
+wireless.1.ratelimit.up=1024
+wireless.1.schedule_enabled=disabled
+wireless.1.security=none
+wireless.1.ssid=kek
+wireless.1.status=enabled
+wireless.1.uapsd=disabled
+wireless.1.usage=user
+wireless.1.vport=disabled
+wireless.1.vwire=disabled
+wireless.1.wds=disabled
+wireless.1.wmm=enabled
+wireless.2.addmtikie=disabled
+wireless.2.authmode=1
+wireless.2.autowds=disabled
+wireless.2.devname=ath1
+wireless.2.hide_ssid=false
+wireless.2.is_guest=false
+wireless.2.l2_isolation=disabled
+wireless.2.mac_acl.policy=deny
+wireless.2.mac_acl.status=enabled
+wireless.2.mode=master
+wireless.2.parent=wifi1
+wireless.2.pureg=1
+wireless.2.puren=0
+wireless.2.ratelimit.down=2048
+wireless.2.ratelimit.mode=per_sta
+wireless.2.ratelimit.status=enabled
+wireless.2.schedule_enabled=disabled
+wireless.2.security=none
+wireless.2.ssid=guest
+wireless.2.status=enabled
+wireless.2.uapsd=disabled
+wireless.2.usage=user
+wireless.2.vport=disabled
+wireless.2.vwire=disabled
+wireless.2.wds=disabled
+wireless.2.wmm=enabled
+wireless.status=enabled`
+
+func TestBuildACLRRateLimit(t *testing.T) {
+	c := Config{
+		Networks: []Network{
+			Network{
+				SSID:              "kek",
+				Pass:              "the_shrekkening",
+				RateLimitUpKbps:   1024,
+				RateLimitDownKbps: 8192,
+			},
+			Network{
+				SSID:               "guest",
+				Pass:               "guest",
+				Band:               Band5G,
+				RateLimitDownKbps:  2048,
+				RateLimitPerClient: true,
+			},
+		},
+	}
+	out, err := c.GenerateSysConf("UAP-AC-LR", "123")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != expectedRateLimit {
+		t.Log(diff.Diff(expectedRateLimit, out))
+		t.Error("Output mismatch")
+	}
+}
+
+func TestBuildACLRBothBands(t *testing.T) {
+	both := Config{
+		Networks: []Network{
+			Network{
+				SSID:            "kek",
+				Pass:            "the_shrekkening",
+				Band:            BandBoth,
+				ProxyARP:        true,
+				RateLimitUpKbps: 1024,
+			},
+		},
+	}
+	duplicated := Config{
+		Networks: []Network{
+			Network{
+				SSID:            "kek",
+				Pass:            "the_shrekkening",
+				ProxyARP:        true,
+				RateLimitUpKbps: 1024,
+			},
+			Network{
+				SSID:            "kek",
+				Pass:            "the_shrekkening",
+				Band:            Band5G,
+				ProxyARP:        true,
+				RateLimitUpKbps: 1024,
+			},
+		},
+	}
+
+	bothOut, err := both.GenerateSysConf("UAP-AC-LR", "123")
+	if err != nil {
+		t.Fatal(err)
+	}
+	duplicatedOut, err := duplicated.GenerateSysConf("UAP-AC-LR", "123")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bothOut != duplicatedOut {
+		t.Log(diff.Diff(duplicatedOut, bothOut))
+		t.Error("Output mismatch")
+	}
+	if !strings.Contains(bothOut, "wireless.2.parent=wifi1\n") {
+		t.Error("Expected second VAP on the 5Ghz radio")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tcs := []struct {
+		name  string
+		c     Config
+		valid bool
+	}{
+		{"no networks", Config{}, false},
+		{"bad band", Config{Networks: []Network{Network{SSID: "kek", Band: 7}}}, false},
+		{"too many vaps", Config{Networks: []Network{Network{SSID: "kek", Band: BandBoth}, Network{SSID: "kek2"}}}, false},
+		{"bandsteer without 5G", Config{Networks: []Network{Network{SSID: "kek"}, Network{SSID: "kek2", Band: Band5G}}, Bandsteer: SteerSettings{Enabled: true}}, false},
+		{"bandsteer", Config{Networks: []Network{Network{SSID: "kek", Band: BandBoth}}, Bandsteer: SteerSettings{Enabled: true}}, true},
+		{"two 2.4Ghz networks", Config{Networks: []Network{Network{SSID: "kek"}, Network{SSID: "kek2"}}}, true},
+	}
+
+	for _, tc := range tcs {
+		err := tc.c.Validate()
+		if tc.valid && err != nil {
+			t.Errorf("%s: unexpected error: %v", tc.name, err)
+		}
+		if !tc.valid && err == nil {
+			t.Errorf("%s: expected error", tc.name)
+		}
+		if _, err := tc.c.GenerateSysConf("UAP-AC-LR", "123"); !tc.valid && err == nil {
+			t.Errorf("%s: expected error generating system configuration", tc.name)
+		}
+	}
+
+	// Overrides are validated once resolved, as they can replace valid site settings.
+	site := &Config{Networks: []Network{Network{SSID: "kek", Band: BandBoth}}, Bandsteer: SteerSettings{Enabled: true}}
+	c := Resolve(site, &Override{Networks: []Network{Network{SSID: "meeting-room"}}})
+	if _, err := c.GenerateSysConf("UAP-AC-LR", "123"); err == nil {
+		t.Error("Expected error generating system configuration for override which cannot be band steered")
+	}
+}
+
+func TestInformURL(t *testing.T) {
+	for _, tc := range []struct {
+		localAddr, listener, expected string
+	}{
+		{"192.168.1.2", ":8421", "http://192.168.1.2:8421/inform"},
+		{"fd00::2", ":8421", "http://[fd00::2]:8421/inform"},
+		{"[fd00::2]", ":8080", "http://[fd00::2]:8080/inform"},
+		{"fd00::2", "[::]:8421", "http://[fd00::2]:8421/inform"},
+		{"unifi.lan", "0.0.0.0:8421", "http://unifi.lan:8421/inform"},
+	} {
+		if out := InformURL(tc.localAddr, tc.listener); out != tc.expected {
+			t.Errorf("InformURL(%q, %q) = %q, expected %q", tc.localAddr, tc.listener, out, tc.expected)
+		}
+	}
+
+	mgmt, err := (&Config{}).GenerateMgmtConf("key", "123", "fd00::2", ":8421")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(mgmt, "mgmt.servers.1.url=http://[fd00::2]:8421/inform\n") {
+		t.Errorf("Expected bracketed inform URL, got:\n%s", mgmt)
+	}
+}
@@ -0,0 +1,96 @@
+package config
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+)
+
+// Devices store the admin password as a crypt(3) hash in users.1.password. We use the
+// MD5 ($1$) scheme, as it is understood by busybox on all supported devices.
+
+const cryptAlphabet = "./0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+// HashPassword returns the crypt(3) hash of the password, with a random salt.
+func HashPassword(pw string) (string, error) {
+	r := make([]byte, 8)
+	if _, err := rand.Read(r); err != nil {
+		return "", err
+	}
+	salt := make([]byte, len(r))
+	for i, b := range r {
+		salt[i] = cryptAlphabet[int(b)%len(cryptAlphabet)]
+	}
+	return md5Crypt([]byte(pw), salt), nil
+}
+
+// md5Crypt implements the FreeBSD MD5-based crypt(3) scheme.
+func md5Crypt(pw, salt []byte) string {
+	const magic = "$1$"
+	if len(salt) > 8 {
+		salt = salt[:8]
+	}
+
+	alt := md5.New()
+	alt.Write(pw)
+	alt.Write(salt)
+	alt.Write(pw)
+	altSum := alt.Sum(nil)
+
+	ctx := md5.New()
+	ctx.Write(pw)
+	ctx.Write([]byte(magic))
+	ctx.Write(salt)
+	for i := len(pw); i > 0; i -= 16 {
+		if i > 16 {
+			ctx.Write(altSum)
+		} else {
+			ctx.Write(altSum[:i])
+		}
+	}
+	for i := len(pw); i > 0; i >>= 1 {
+		if i&1 != 0 {
+			ctx.Write([]byte{0})
+		} else {
+			ctx.Write(pw[:1])
+		}
+	}
+	final := ctx.Sum(nil)
+
+	for i := 0; i < 1000; i++ {
+		round := md5.New()
+		if i&1 != 0 {
+			round.Write(pw)
+		} else {
+			round.Write(final)
+		}
+		if i%3 != 0 {
+			round.Write(salt)
+		}
+		if i%7 != 0 {
+			round.Write(pw)
+		}
+		if i&1 != 0 {
+			round.Write(final)
+		} else {
+			round.Write(pw)
+		}
+		final = round.Sum(nil)
+	}
+
+	out := []byte(magic)
+	out = append(out, salt...)
+	out = append(out, '$')
+	for _, g := range [][3]int{{0, 6, 12}, {1, 7, 13}, {2, 8, 14}, {3, 9, 15}, {4, 10, 5}} {
+		v := uint(final[g[0]])<<16 | uint(final[g[1]])<<8 | uint(final[g[2]])
+		for j := 0; j < 4; j++ {
+			out = append(out, cryptAlphabet[v&0x3f])
+			v >>= 6
+		}
+	}
+	v := uint(final[11])
+	for j := 0; j < 2; j++ {
+		out = append(out, cryptAlphabet[v&0x3f])
+		v >>= 6
+	}
+	return string(out)
+}
@@ -0,0 +1,40 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMD5Crypt(t *testing.T) {
+	// Generated with: openssl passwd -1 -salt <salt> <password>
+	tcs := []struct {
+		pw, salt, expected string
+	}{
+		{"password", "saltsalt", "$1$saltsalt$qjXMvbEw8oaL.CzflDtaK/"},
+		{"a much longer password that exceeds sixteen bytes", "ab", "$1$ab$e/XXzmFKRn1iAwWUeS2Te/"},
+		{"", "12345678", "$1$12345678$xek.CpjQUVgdf/P2N9KQf/"},
+	}
+	for _, tc := range tcs {
+		if out := md5Crypt([]byte(tc.pw), []byte(tc.salt)); out != tc.expected {
+			t.Errorf("md5Crypt(%q, %q) = %q, expected %q", tc.pw, tc.salt, out, tc.expected)
+		}
+	}
+}
+
+func TestHashPassword(t *testing.T) {
+	h1, err := HashPassword("kek")
+	if err != nil {
+		t.Fatal(err)
+	}
+	h2, err := HashPassword("kek")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h1 == h2 {
+		t.Error("Expected different salts")
+	}
+	spl := strings.Split(h1, "$")
+	if len(spl) != 4 || spl[1] != "1" || md5Crypt([]byte("kek"), []byte(spl[2])) != h1 {
+		t.Errorf("Hash %q does not verify", h1)
+	}
+}
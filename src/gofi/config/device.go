@@ -0,0 +1,133 @@
+package config
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// LED modes
+const (
+	LEDOn    = 0
+	LEDOff   = 1
+	LEDNight = 2 // LEDs are on, except for between NightStart and NightEnd.
+)
+
+// now is overridden in tests.
+var now = time.Now
+
+// LEDSettings describes the behaviour of the status LED on a device.
+type LEDSettings struct {
+	Mode       int
+	NightStart int // Hour of the day (local time, 0-23) the LEDs turn off.
+	NightEnd   int // Hour of the day (local time, 0-23) the LEDs turn back on.
+}
+
+// EnabledAt returns true if the LEDs should be on at the given time.
+func (l LEDSettings) EnabledAt(t time.Time) bool {
+	switch l.Mode {
+	case LEDOff:
+		return false
+	case LEDNight:
+		h := t.Hour()
+		if l.NightStart <= l.NightEnd {
+			return h < l.NightStart || h >= l.NightEnd
+		}
+		// Night wraps around midnight.
+		return h < l.NightStart && h >= l.NightEnd
+	}
+	return true
+}
+
+// String returns the LED settings in the form accepted by ParseLEDSettings.
+func (l LEDSettings) String() string {
+	switch l.Mode {
+	case LEDOff:
+		return "off"
+	case LEDNight:
+		return fmt.Sprintf("night:%d-%d", l.NightStart, l.NightEnd)
+	}
+	return "on"
+}
+
+// ParseLEDSettings parses LED settings of the form 'on', 'off', or 'night:<start hour>-<end hour>'.
+func ParseLEDSettings(s string) (LEDSettings, error) {
+	switch s {
+	case "", "on":
+		return LEDSettings{Mode: LEDOn}, nil
+	case "off":
+		return LEDSettings{Mode: LEDOff}, nil
+	}
+
+	if !strings.HasPrefix(s, "night:") {
+		return LEDSettings{}, errors.New("invalid LED mode " + strconv.Quote(s))
+	}
+	hours := strings.Split(strings.TrimPrefix(s, "night:"), "-")
+	if len(hours) != 2 {
+		return LEDSettings{}, errors.New("expected night:<start hour>-<end hour>, got " + strconv.Quote(s))
+	}
+	start, err := strconv.Atoi(hours[0])
+	if err != nil || start < 0 || start > 23 {
+		return LEDSettings{}, errors.New("invalid night start hour " + strconv.Quote(hours[0]))
+	}
+	end, err := strconv.Atoi(hours[1])
+	if err != nil || end < 0 || end > 23 {
+		return LEDSettings{}, errors.New("invalid night end hour " + strconv.Quote(hours[1]))
+	}
+	return LEDSettings{Mode: LEDNight, NightStart: start, NightEnd: end}, nil
+}
+
+// hostnameFromAlias converts a human-readable alias into a valid hostname,
+// replacing any invalid characters with dashes.
+func hostnameFromAlias(alias string) string {
+	out := []byte(strings.TrimSpace(alias))
+	for i, c := range out {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-') {
+			out[i] = '-'
+		}
+	}
+	if len(out) > 63 {
+		out = out[:63]
+	}
+	return strings.Trim(string(out), "-")
+}
+
+// applyDeviceConf sets options common to all kinds of devices.
+func (b *Config) applyDeviceConf(config *Section) error {
+	if hostname := hostnameFromAlias(b.Alias); hostname != "" {
+		config.Get("resolv").Get("host").Get("1").Get("name").SetVal(hostname)
+		config.Get("resolv").Get("host").Get("1").Get("status").SetVal("enabled")
+	}
+
+	if b.AdminPasswordHash != "" {
+		user := b.AdminUser
+		if user == "" {
+			user = "ubnt"
+		}
+		config.Get("users").Get("status").SetVal("enabled")
+		config.Get("users").Get("1").Get("name").SetVal(user)
+		config.Get("users").Get("1").Get("password").SetVal(b.AdminPasswordHash)
+		config.Get("users").Get("1").Get("status").SetVal("enabled")
+	}
+
+	for i, k := range b.AuthorizedKeys {
+		pub, comment, _, _, err := ssh.ParseAuthorizedKey([]byte(k))
+		if err != nil {
+			return fmt.Errorf("invalid authorized key %d: %v", i+1, err)
+		}
+		key := config.Get("sshd").Get("auth").Get("key").Get(strconv.Itoa(i + 1))
+		key.Get("type").SetVal(pub.Type())
+		key.Get("value").SetVal(base64.StdEncoding.EncodeToString(pub.Marshal()))
+		key.Get("comment").SetVal(comment)
+		key.Get("status").SetVal("enabled")
+	}
+	if len(b.AuthorizedKeys) > 0 {
+		config.Get("sshd").Get("auth").Get("key").Get("status").SetVal("enabled")
+	}
+	return nil
+}
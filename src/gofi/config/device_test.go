@@ -0,0 +1,133 @@
+package config
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseLEDSettings(t *testing.T) {
+	tcs := []struct {
+		in  string
+		out LEDSettings
+	}{
+		{"", LEDSettings{Mode: LEDOn}},
+		{"on", LEDSettings{Mode: LEDOn}},
+		{"off", LEDSettings{Mode: LEDOff}},
+		{"night:22-7", LEDSettings{Mode: LEDNight, NightStart: 22, NightEnd: 7}},
+	}
+	for _, tc := range tcs {
+		l, err := ParseLEDSettings(tc.in)
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", tc.in, err)
+		}
+		if l != tc.out {
+			t.Errorf("%q: expected %+v, got %+v", tc.in, tc.out, l)
+		}
+	}
+
+	for _, in := range []string{"blink", "night:", "night:22", "night:24-7", "night:a-b"} {
+		if _, err := ParseLEDSettings(in); err == nil {
+			t.Errorf("%q: expected error", in)
+		}
+	}
+}
+
+func TestLEDEnabledAt(t *testing.T) {
+	at := func(hour int) time.Time {
+		return time.Date(2017, 10, 1, hour, 30, 0, 0, time.Local)
+	}
+	night := LEDSettings{Mode: LEDNight, NightStart: 22, NightEnd: 7}
+	for h, expected := range map[int]bool{21: true, 22: false, 2: false, 6: false, 7: true, 12: true} {
+		if night.EnabledAt(at(h)) != expected {
+			t.Errorf("night mode at %d:30: expected %v", h, expected)
+		}
+	}
+
+	early := LEDSettings{Mode: LEDNight, NightStart: 1, NightEnd: 5}
+	for h, expected := range map[int]bool{0: true, 1: false, 4: false, 5: true} {
+		if early.EnabledAt(at(h)) != expected {
+			t.Errorf("early night mode at %d:30: expected %v", h, expected)
+		}
+	}
+
+	if (LEDSettings{Mode: LEDOff}).EnabledAt(at(12)) || !(LEDSettings{}).EnabledAt(at(2)) {
+		t.Error("Unexpected LED state")
+	}
+}
+
+func TestAliasAndLED(t *testing.T) {
+	defer func() { now = time.Now }()
+	now = func() time.Time {
+		return time.Date(2017, 10, 1, 23, 0, 0, 0, time.Local)
+	}
+
+	c := Resolve(&Config{
+		Networks: []Network{
+			Network{
+				SSID: "kek",
+				Pass: "the_shrekkening",
+			},
+		},
+		LED: LEDSettings{Mode: LEDNight, NightStart: 22, NightEnd: 7},
+	}, &Override{Alias: stringPtr("Meeting room #2")})
+
+	out, err := c.GenerateSysConf("UAP-AC-LR", "123")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out, "resolv.host.1.name=Meeting-room--2\n") {
+		t.Error("Expected hostname to be set from alias")
+	}
+
+	mgmt, err := c.GenerateMgmtConf("key", "123", "192.168.1.2", ":8421")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(mgmt, "led_enabled=false") {
+		t.Errorf("Expected LEDs to be disabled at night, got:\n%s", mgmt)
+	}
+}
+
+func stringPtr(s string) *string {
+	return &s
+}
+
+func TestAdminCredentials(t *testing.T) {
+	c := Config{
+		Networks: []Network{
+			Network{
+				SSID: "kek",
+				Pass: "the_shrekkening",
+			},
+		},
+		AdminPasswordHash: "$1$saltsalt$qjXMvbEw8oaL.CzflDtaK/",
+		AuthorizedKeys:    []string{"ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIC/FTGcOqYtWlt+89poJFhJ2IHwuGwTsJKgPkQO29Vsh admin@gofi"},
+	}
+
+	for _, model := range []string{"UAP-AC-LR", "USW-8P-60"} {
+		out, err := c.GenerateSysConf(model, "123")
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, line := range []string{
+			"users.1.name=ubnt\n",
+			"users.1.password=$1$saltsalt$qjXMvbEw8oaL.CzflDtaK/\n",
+			"sshd.auth.key.1.comment=admin@gofi\n",
+			"sshd.auth.key.1.type=ssh-ed25519\n",
+			"sshd.auth.key.1.value=AAAAC3NzaC1lZDI1NTE5AAAAIC/FTGcOqYtWlt+89poJFhJ2IHwuGwTsJKgPkQO29Vsh\n",
+		} {
+			if !strings.Contains(out, line) {
+				t.Errorf("%s: output is missing %q", model, line)
+			}
+		}
+		if strings.Contains(out, "VvpvCwhccFv6Q") {
+			t.Errorf("%s: output contains the default password hash", model)
+		}
+	}
+
+	c.AuthorizedKeys = []string{"ssh-rsa lol"}
+	if _, err := c.GenerateSysConf("UAP-AC-LR", "123"); err == nil {
+		t.Error("Expected error for invalid authorized key")
+	}
+}
@@ -0,0 +1,70 @@
+package config
+
+// Override describes a partial configuration, layered on top of a Config.
+// Nil (or empty) fields are inherited from the configuration being overridden.
+type Override struct {
+	Networks        []Network      `json:",omitempty"`
+	Bandsteer       *SteerSettings `json:",omitempty"`
+	Txpower         *int           `json:",omitempty"`
+	MinRSSI         *int           `json:",omitempty"`
+	MinRSSIInterval *int           `json:",omitempty"`
+	Channel2G       *int           `json:",omitempty"`
+	Channel5G       *int           `json:",omitempty"`
+	Alias           *string        `json:",omitempty"`
+	LED             *LEDSettings   `json:",omitempty"`
+
+	// Patches are applied after any patches inherited from the overridden configuration.
+	Patches []Patch `json:",omitempty"`
+}
+
+// Apply modifies the config by setting all fields specified in the override.
+func (o *Override) Apply(c *Config) {
+	if o == nil {
+		return
+	}
+	if len(o.Networks) > 0 {
+		c.Networks = make([]Network, len(o.Networks))
+		copy(c.Networks, o.Networks)
+	}
+	if o.Bandsteer != nil {
+		c.Bandsteer = *o.Bandsteer
+	}
+	if o.Txpower != nil {
+		c.Txpower = *o.Txpower
+	}
+	if o.MinRSSI != nil {
+		c.MinRSSI = *o.MinRSSI
+	}
+	if o.MinRSSIInterval != nil {
+		c.MinRSSIInterval = *o.MinRSSIInterval
+	}
+	if o.Channel2G != nil {
+		c.Radio2G.Channel = *o.Channel2G
+	}
+	if o.Channel5G != nil {
+		c.Radio5G.Channel = *o.Channel5G
+	}
+	if o.Alias != nil {
+		c.Alias = *o.Alias
+	}
+	if o.LED != nil {
+		c.LED = *o.LED
+	}
+	if len(o.Patches) > 0 {
+		c.Patches = append(c.Patches[:len(c.Patches):len(c.Patches)], o.Patches...)
+	}
+}
+
+// Resolve returns the effective configuration obtained by applying each override to
+// the site-wide configuration in turn. Later overrides take precedence, so they should
+// be ordered from least to most specific (ie: group, then AP). The site config is not modified.
+func Resolve(site *Config, overrides ...*Override) *Config {
+	out := *site
+	out.Networks = make([]Network, len(site.Networks))
+	copy(out.Networks, site.Networks)
+
+	for _, o := range overrides {
+		o.Apply(&out)
+	}
+	return &out
+}
@@ -0,0 +1,91 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+func intPtr(i int) *int {
+	return &i
+}
+
+func TestResolveInheritance(t *testing.T) {
+	site := &Config{
+		Networks: []Network{
+			Network{
+				SSID: "kek",
+				Pass: "the_shrekkening",
+			},
+		},
+		Txpower: 20,
+		MinRSSI: 70,
+	}
+	group := &Override{
+		Txpower:   intPtr(12),
+		Channel2G: intPtr(6),
+	}
+	ap := &Override{
+		Txpower:   intPtr(8),
+		Channel5G: intPtr(44),
+	}
+
+	c := Resolve(site, group, ap)
+	if c.Txpower != 8 {
+		t.Errorf("Expected AP txpower to win, got %d", c.Txpower)
+	}
+	if c.Radio2G.Channel != 6 {
+		t.Errorf("Expected group 2.4Ghz channel to be inherited, got %d", c.Radio2G.Channel)
+	}
+	if c.Radio5G.Channel != 44 {
+		t.Errorf("Expected AP 5Ghz channel, got %d", c.Radio5G.Channel)
+	}
+	if c.MinRSSI != 70 {
+		t.Errorf("Expected site min RSSI to be inherited, got %d", c.MinRSSI)
+	}
+	if site.Txpower != 20 || site.Radio2G.Channel != 0 {
+		t.Error("Site config was modified")
+	}
+}
+
+func TestResolveNetworksNotShared(t *testing.T) {
+	site := &Config{
+		Networks: []Network{
+			Network{
+				SSID: "kek",
+				Pass: "the_shrekkening",
+			},
+		},
+	}
+
+	c := Resolve(site, nil)
+	c.Networks[0].SSID = "changed"
+	if site.Networks[0].SSID != "kek" {
+		t.Error("Resolved config shares networks with site config")
+	}
+
+	c = Resolve(site, &Override{Networks: []Network{Network{SSID: "meeting_room", Pass: "lol"}}})
+	if len(c.Networks) != 1 || c.Networks[0].SSID != "meeting_room" {
+		t.Errorf("Expected networks to be replaced, got %+v", c.Networks)
+	}
+}
+
+func TestBuildACLRChannel(t *testing.T) {
+	c := Resolve(&Config{
+		Networks: []Network{
+			Network{
+				SSID: "kek",
+				Pass: "the_shrekkening",
+			},
+		},
+	}, &Override{Channel2G: intPtr(11), Channel5G: intPtr(149)})
+
+	out, err := c.GenerateSysConf("UAP-AC-LR", "123")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{"radio.1.channel=11", "radio.2.channel=149"} {
+		if !strings.Contains(out, line+"\n") {
+			t.Errorf("Output is missing %q", line)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package config
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// managedPrefixes lists the system.cfg keys which gofi generates from typed fields in Config.
+// A patch which touches these is probably fighting gofi, so a warning is emitted.
+var managedPrefixes = []string{
+	"aaa.",
+	"wireless.",
+	"bridge.1.port.",
+	"bandsteering.",
+	"atf.",
+	"radio.1.atf.",
+	"radio.2.atf.",
+	"radio.1.cck.",
+	"radio.1.channel",
+	"radio.2.channel",
+	"radio.1.minrate.",
+	"radio.2.minrate.",
+	"radio.1.txpower",
+	"radio.2.txpower",
+	"resolv.host.",
+	"sshd.auth.",
+	"stamgr.",
+	"ubntroam.",
+	"connectivity.",
+	"users.",
+}
+
+// Patch describes raw changes to the generated system configuration, for firmware settings which
+// do not have a typed field in Config. Patches are applied after all other configuration is generated.
+type Patch struct {
+	Model  string            `json:",omitempty"` // If set, the patch only applies to devices of this model.
+	Set    map[string]string `json:",omitempty"` // Dotted keys to set, ie: radio.1.ampdu.status=disabled
+	Delete []string          `json:",omitempty"` // Dotted keys to remove, along with their children.
+}
+
+// AppliesTo returns true if the patch should be applied to the given model.
+func (p *Patch) AppliesTo(modelName string) bool {
+	return p.Model == "" || p.Model == modelName
+}
+
+// ManagedKeys returns the keys touched by the patch which gofi would otherwise manage itself.
+func (p *Patch) ManagedKeys() []string {
+	var out []string
+	for _, k := range p.keys() {
+		for _, prefix := range managedPrefixes {
+			if strings.HasPrefix(k, prefix) || strings.HasPrefix(prefix, k+".") {
+				out = append(out, k)
+				break
+			}
+		}
+	}
+	return out
+}
+
+func (p *Patch) keys() []string {
+	out := make([]string, 0, len(p.Set)+len(p.Delete))
+	for k := range p.Set {
+		out = append(out, k)
+	}
+	out = append(out, p.Delete...)
+	sort.Strings(out)
+	return out
+}
+
+// Apply performs the deletions and then the assignments specified in the patch.
+func (p *Patch) Apply(conf *Section) error {
+	for _, k := range p.keys() {
+		if k == "" || strings.HasPrefix(k, ".") || strings.HasSuffix(k, ".") || strings.Contains(k, "..") || strings.ContainsAny(k, "=\n\r") {
+			return fmt.Errorf("invalid patch key %q", k)
+		}
+	}
+	for k, v := range p.Set {
+		if strings.ContainsAny(v, "\n\r") {
+			return fmt.Errorf("invalid value for patch key %q", k)
+		}
+	}
+	for _, k := range p.Delete {
+		conf.Delete(k)
+	}
+	for k, v := range p.Set {
+		conf.GetPath(k).SetVal(v)
+	}
+	return nil
+}
+
+// applyPatches applies all patches relevant to the model, in order.
+func (b *Config) applyPatches(conf *Section, modelName string) error {
+	for i := range b.Patches {
+		p := &b.Patches[i]
+		if !p.AppliesTo(modelName) {
+			continue
+		}
+		if managed := p.ManagedKeys(); len(managed) > 0 {
+			fmt.Printf("[CONFIG] Warning: patch overrides keys managed by gofi: %s\n", strings.Join(managed, ", "))
+		}
+		if err := p.Apply(conf); err != nil {
+			return err
+		}
+	}
+	return nil
+}
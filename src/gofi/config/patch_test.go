@@ -0,0 +1,73 @@
+package config
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestPatchManagedKeys(t *testing.T) {
+	p := Patch{
+		Set: map[string]string{
+			"radio.1.ampdu.status": "disabled",
+			"radio.1.txpower":      "5",
+			"aaa.1.wpa.psk":        "lol",
+		},
+		Delete: []string{"radio", "syslog.remote"},
+	}
+	expected := []string{"aaa.1.wpa.psk", "radio", "radio.1.txpower"}
+	if m := p.ManagedKeys(); !reflect.DeepEqual(m, expected) {
+		t.Errorf("Expected %v, got %v", expected, m)
+	}
+}
+
+func TestPatchInvalidKey(t *testing.T) {
+	for _, k := range []string{"", ".a", "a.", "a..b", "a=b", "a\nb"} {
+		p := Patch{Set: map[string]string{k: "1"}}
+		if err := p.Apply(newSect()); err == nil {
+			t.Errorf("Expected error for key %q", k)
+		}
+	}
+	p := Patch{Set: map[string]string{"a.b": "1\nc.d=2"}}
+	if err := p.Apply(newSect()); err == nil {
+		t.Error("Expected error for multi-line value")
+	}
+}
+
+func TestBuildACLRPatches(t *testing.T) {
+	c := Resolve(&Config{
+		Networks: []Network{
+			Network{
+				SSID: "kek",
+				Pass: "the_shrekkening",
+			},
+		},
+		Patches: []Patch{
+			Patch{
+				Set:    map[string]string{"syslog.remote.status": "enabled", "syslog.remote.ip": "10.0.0.1"},
+				Delete: []string{"ntpclient"},
+			},
+			Patch{
+				Model: "UAP-AC-PRO",
+				Set:   map[string]string{"radio.1.ampdu.status": "disabled"},
+			},
+		},
+	}, &Override{
+		Patches: []Patch{
+			Patch{Set: map[string]string{"syslog.remote.ip": "10.0.0.2"}},
+		},
+	})
+
+	out, err := c.GenerateSysConf("UAP-AC-LR", "123")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{"syslog.remote.status=enabled\n", "syslog.remote.ip=10.0.0.2\n", "radio.1.ampdu.status=enabled\n"} {
+		if !strings.Contains(out, line) {
+			t.Errorf("Output is missing %q", line)
+		}
+	}
+	if strings.Contains(out, "ntpclient") {
+		t.Error("Expected ntpclient keys to be deleted")
+	}
+}
@@ -35,6 +35,33 @@ func (s *Section) Get(name string) *Section {
 	return n
 }
 
+// GetPath returns the section at the given dotted path (ie: radio.1.txpower), creating
+// any sections which do not exist.
+func (s *Section) GetPath(path string) *Section {
+	cursor := s
+	for _, name := range strings.Split(path, ".") {
+		cursor = cursor.Get(name)
+	}
+	return cursor
+}
+
+// Delete removes the section at the given dotted path, along with all its children.
+// Returns true if the section existed.
+func (s *Section) Delete(path string) bool {
+	spl := strings.Split(path, ".")
+	cursor := s
+	for _, name := range spl[:len(spl)-1] {
+		next, ok := cursor.NamedSubs[name]
+		if !ok {
+			return false
+		}
+		cursor = next
+	}
+	_, ok := cursor.NamedSubs[spl[len(spl)-1]]
+	delete(cursor.NamedSubs, spl[len(spl)-1])
+	return ok
+}
+
 // SetVal sets the value of the section.
 func (s *Section) SetVal(v string) {
 	s.Value = v
@@ -131,3 +131,34 @@ func in(a string, m *Section) bool {
 	_, ok := m.NamedSubs[a]
 	return ok
 }
+
+func TestGetPathAndDelete(t *testing.T) {
+	obj, err := Parse([]byte(basicInput))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if obj.GetPath("aaa.1.wpa.psk").Value != "54645654546" {
+		t.Error("Expected aaa.1.wpa.psk=54645654546")
+	}
+	obj.GetPath("aaa.3.ssid").SetVal("new")
+	if obj.Get("aaa").Get("3").Get("ssid").Value != "new" {
+		t.Error("Expected aaa.3.ssid=new")
+	}
+
+	if !obj.Delete("aaa.2") {
+		t.Error("Expected aaa.2 to be deleted")
+	}
+	if obj.Delete("aaa.2") || obj.Delete("nope.1") {
+		t.Error("Expected deletion of missing section to return false")
+	}
+	out, err := obj.Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(out, "aaa.2.") {
+		t.Error("Output still contains aaa.2")
+	}
+	if !strings.Contains(out, "aaa.1.ssid=") {
+		t.Error("Output is missing aaa.1")
+	}
+}
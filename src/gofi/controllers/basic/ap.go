@@ -8,7 +8,8 @@ import (
 	"gofi/config"
 	"gofi/manager"
 	"gofi/packet"
-	"strings"
+	"net"
+	"reflect"
 )
 
 // ap is a DAO proxying configuration storage and requests through the global config/statefile.
@@ -24,23 +25,95 @@ func (a *ap) MAC() [6]byte {
 	return a.MAddr
 }
 
-func (a *ap) GetState() int {
-	return localState.AccessPoints[a.HexAddr].State
+// state returns a copy of the AP's state.
+func (a *ap) state() apState {
+	stateLock.Lock()
+	defer stateLock.Unlock()
+	return localState.AccessPoints[a.HexAddr]
 }
 
-func (a *ap) SetState(s int) {
-	ac := localState.AccessPoints[a.HexAddr]
-	ac.State = s
+// update applies fn to the AP's state and saves the statefile. Nothing is saved if the AP has
+// been forgotten.
+func (a *ap) update(fn func(ac *apState)) {
+	stateLock.Lock()
+	defer stateLock.Unlock()
+	ac, ok := localState.AccessPoints[a.HexAddr]
+	if !ok {
+		return
+	}
+	fn(&ac)
 	localState.AccessPoints[a.HexAddr] = ac
 	flushConfig()
 }
 
+func (a *ap) GetState() int {
+	return a.state().State
+}
+
+func (a *ap) SetState(s int) {
+	a.update(func(ac *apState) { ac.State = s })
+}
+
 func (a *ap) AuthKey() []byte {
-	return localState.AccessPoints[a.HexAddr].AuthKey
+	return a.state().AuthKey
+}
+
+func (a *ap) SSHUser() string {
+	return a.state().SSHUser
 }
 
 func (a *ap) SSHPw() string {
-	return localState.AccessPoints[a.HexAddr].SSHPw
+	ac := a.state()
+	if len(ac.SSHPwEnc) == 0 {
+		if ac.SSHPw == "" {
+			return "ubnt"
+		}
+		return ac.SSHPw
+	}
+	pw, err := openSecret(ac.SSHPwEnc)
+	if err != nil {
+		fmt.Printf("[CONFIG] [%x] Failed to decrypt SSH password: %s\n", a.MAddr, err)
+	}
+	return pw
+}
+
+// SetSSHPw stores the password encrypted, along with the hash which is pushed to the AP.
+func (a *ap) SetSSHPw(pw string) error {
+	sealed, err := sealSecret(pw)
+	if err != nil {
+		return err
+	}
+	hash, err := config.HashPassword(pw)
+	if err != nil {
+		return err
+	}
+	a.update(func(ac *apState) {
+		ac.SSHPw = ""
+		ac.SSHPwEnc = sealed
+		ac.SSHPwHash = hash
+	})
+	return nil
+}
+
+func (a *ap) HostKey() string {
+	return a.state().HostKey
+}
+
+func (a *ap) SetHostKey(k string) error {
+	a.update(func(ac *apState) { ac.HostKey = k })
+	return nil
+}
+
+// Forget deletes the AP's key, state and last inform from the controller.
+func (a *ap) Forget() error {
+	stateLock.Lock()
+	delete(localState.AccessPoints, a.HexAddr)
+	flushConfig()
+	stateLock.Unlock()
+	lastInformLock.Lock()
+	delete(lastInformForMAC, manager.FormatMAC(a.MAddr))
+	lastInformLock.Unlock()
+	return nil
 }
 
 func (a *ap) GetIP() string {
@@ -48,22 +121,51 @@ func (a *ap) GetIP() string {
 }
 
 func (a *ap) GetConfigVersion() string {
-	return localState.AccessPoints[a.HexAddr].ConfigVersion
+	return a.state().ConfigVersion
 }
 
 func (a *ap) SetConfigVersion(c string) {
-	ac := localState.AccessPoints[a.HexAddr]
-	ac.ConfigVersion = c
-	localState.AccessPoints[a.HexAddr] = ac
-	flushConfig()
+	a.update(func(ac *apState) { ac.ConfigVersion = c })
 }
 
+// GetConfig resolves the site configuration, the AP's group and the AP's own overrides
+// into the effective configuration of the AP, which is recorded in the statefile.
 func (a *ap) GetConfig() *config.Config {
+	stateLock.Lock()
+	ac := localState.AccessPoints[a.HexAddr]
+	var group *config.Override
+	if ac.Group != "" {
+		g, ok := localState.Groups[ac.Group]
+		if !ok {
+			fmt.Printf("[CONFIG] [%x] Unknown group %q, ignoring\n", a.MAddr, ac.Group)
+		} else {
+			group = &g
+		}
+	}
+	site := siteConfig()
+	site.Patches = localState.Patches
+	stateLock.Unlock()
+
+	c := config.Resolve(site, group, &ac.Overrides)
+	c.AdminUser = ac.SSHUser
+	c.AdminPasswordHash = ac.SSHPwHash
+	c.AuthorizedKeys = authorizedKeys
+	if !reflect.DeepEqual(c, ac.Config) {
+		a.update(func(ac *apState) { ac.Config = c })
+	}
+	return c
+}
+
+// siteConfig returns the site-wide defaults, as specified on the command line.
+func siteConfig() *config.Config {
 	c := &config.Config{
 		Networks: []config.Network{
 			config.Network{
-				SSID: *ssid,
-				Pass: *password,
+				SSID:               *ssid,
+				Pass:               *password,
+				RateLimitUpKbps:    *rateLimitUp,
+				RateLimitDownKbps:  *rateLimitDown,
+				RateLimitPerClient: *rateLimitPerClient,
 			},
 		},
 		Bandsteer: config.SteerSettings{
@@ -72,28 +174,35 @@ func (a *ap) GetConfig() *config.Config {
 		},
 		Txpower: *txPower,
 		MinRSSI: *minRSSI,
+		LED:     ledSettings,
+		Radio2G: config.RadioSettings{
+			AirtimeFairness:  *airtimeFairness,
+			MinBasicRateKbps: *minRate,
+		},
+		Radio5G: config.RadioSettings{
+			AirtimeFairness:  *airtimeFairness,
+			MinBasicRateKbps: *minRate,
+		},
 	}
 
 	if *do5G {
-		c.Networks = append(c.Networks, config.Network{
-			SSID:   *ssid,
-			Pass:   *password,
-			Is5Ghz: true,
-		})
+		c.Networks[0].Band = config.BandBoth
 	}
 	return c
 }
 
 func onControllerDoesntKnowAP(ip string, i *packet.Inform) (manager.AP, error) {
 	haddr := hex.EncodeToString(i.APMAC[:])
+	stateLock.Lock()
 	_, known := localState.AccessPoints[haddr]
+	stateLock.Unlock()
 	if !known {
 		return nil, errors.New("Ap " + haddr + " not known")
 	}
 	return &ap{
 		HexAddr: haddr,
 		MAddr:   i.APMAC,
-		IP:      strings.Split(ip, ":")[0],
+		IP:      manager.Host(ip),
 	}, nil
 }
 
@@ -101,14 +210,27 @@ func onDiscoveryPacket(localAddr, listenerAddr string, discoveryPkt *packet.Disc
 	var adoptCfg *adopt.Config
 	haddr := hex.EncodeToString(discoveryPkt.MAC[:])
 
-	if _, isKnown := localState.AccessPoints[haddr]; isKnown {
+	stateLock.Lock()
+	defer stateLock.Unlock()
+	prev, isKnown := localState.AccessPoints[haddr]
+	if isKnown && !discoveryPkt.IsDefault {
 		fmt.Printf("Should not need to adopt %x - already known\n", discoveryPkt.MAC)
 	} else {
-		adoptCfg = adopt.NewConfig(strings.Split(discoveryPkt.IPInfo.String(), ":")[0]+":22", localAddr+listenerAddr, "ubnt")
+		if isKnown {
+			fmt.Printf("[DISCOVERY] [%x] Known AP has been reset to factory defaults, issuing a new key\n", discoveryPkt.MAC)
+		}
+		adoptCfg = adopt.NewConfig(net.JoinHostPort(manager.Host(discoveryPkt.IPInfo.String()), "22"), config.ControllerAddr(localAddr, listenerAddr), "ubnt")
+		sealed, err := sealSecret(adoptCfg.Pass)
+		if err != nil {
+			return nil, nil, err
+		}
+		// A reset AP keeps its group and overrides, but the device has new credentials and host keys.
 		localState.AccessPoints[haddr] = apState{
-			Mac:     discoveryPkt.MAC,
-			AuthKey: adoptCfg.Key,
-			SSHPw:   "ubnt",
+			Mac:       discoveryPkt.MAC,
+			AuthKey:   adoptCfg.Key,
+			SSHPwEnc:  sealed,
+			Group:     prev.Group,
+			Overrides: prev.Overrides,
 		}
 		flushConfig()
 	}
@@ -116,6 +238,6 @@ func onDiscoveryPacket(localAddr, listenerAddr string, discoveryPkt *packet.Disc
 	return &ap{
 		HexAddr: haddr,
 		MAddr:   discoveryPkt.MAC,
-		IP:      strings.Split(discoveryPkt.IPInfo.String(), ":")[0],
+		IP:      manager.Host(discoveryPkt.IPInfo.String()),
 	}, adoptCfg, nil
 }
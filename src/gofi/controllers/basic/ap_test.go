@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"gofi/config"
+	"gofi/manager"
+	"gofi/packet"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// encodeInform returns an inform from the given AP with the given payload, as decoded by the server.
+func encodeInform(t *testing.T, mac [6]byte, key []byte, payload *packet.InformData) *packet.Inform {
+	informPkt := &packet.Inform{APMAC: mac, IV: bytes.Repeat([]byte{0x01}, 16), DataVersion: 1}
+	var err error
+	if informPkt.Data, err = json.Marshal(payload); err != nil {
+		t.Fatal(err)
+	}
+	b, err := informPkt.Marshal(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if informPkt, err = packet.InformDecode(bytes.NewReader(b)); err != nil {
+		t.Fatal(err)
+	}
+	return informPkt
+}
+
+// newTestState points the controller at an empty statefile in a temporary directory, returning a
+// function which removes it.
+func newTestState(t *testing.T) func() {
+	dir, err := ioutil.TempDir("", "gofi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := loadConfig(filepath.Join(dir, "controllerState.json")); err != nil {
+		t.Fatal(err)
+	}
+	if err := loadStateKey(""); err != nil {
+		t.Fatal(err)
+	}
+	return func() { os.RemoveAll(dir) }
+}
+
+func TestReadoptResetAP(t *testing.T) {
+	defer newTestState(t)()
+
+	localState.Groups = map[string]config.Override{"lobby": {}}
+	mac := [6]byte{0xf0, 0x9f, 0xc2, 0xaa, 0xbb, 0xcc}
+	oldKey := bytes.Repeat([]byte{0x42}, 16)
+	localState.AccessPoints[hex.EncodeToString(mac[:])] = apState{
+		Mac:           mac,
+		State:         manager.StateManaged,
+		ConfigVersion: "abc",
+		AuthKey:       oldKey,
+		HostKey:       "ssh-ed25519 AAAA",
+		Group:         "lobby",
+	}
+
+	m, err := manager.New("127.0.0.1:0", "192.168.1.2", nil, onDiscoveryPacket, onControllerDoesntKnowAP, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	// The AP informs with the default key once reset, and must be approved again.
+	defaultInform := &packet.InformData{ModelName: "UAP-AC-LR", Model: "U7LR", IsDefaultConfig: true}
+	if _, err := m.HandleInform("192.168.1.20:41234", encodeInform(t, mac, packet.DefaultKey, defaultInform)); err == nil {
+		t.Error("Expected error for reset AP awaiting approval")
+	}
+	if err := m.Approve(mac); err != nil {
+		t.Fatal(err)
+	}
+	out, err := m.HandleInform("192.168.1.20:41234", encodeInform(t, mac, packet.DefaultKey, defaultInform))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ac := localState.AccessPoints[hex.EncodeToString(mac[:])]
+	if len(ac.AuthKey) != 16 || bytes.Equal(ac.AuthKey, oldKey) || bytes.Equal(ac.AuthKey, packet.DefaultKey) {
+		t.Fatalf("Expected a new key, got %x", ac.AuthKey)
+	}
+	if ac.State != manager.StateAdopted || ac.HostKey != "" || ac.Group != "lobby" || ac.ConfigVersion == "abc" {
+		t.Errorf("Unexpected state after re-adoption: %+v", ac)
+	}
+	reply, err := packet.InformDecode(bytes.NewReader(out))
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := reply.Payload(packet.DefaultKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var cmd packet.CommandData
+	if err := json.Unmarshal(d, &cmd); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(cmd.ManagementConfig, "mgmt.authkey="+hex.EncodeToString(ac.AuthKey)+"\n") {
+		t.Errorf("Expected management config with new key, got %+v", cmd)
+	}
+
+	// The AP then informs with its new key.
+	if _, err := m.HandleInform("192.168.1.20:41234", encodeInform(t, mac, ac.AuthKey, &packet.InformData{ModelName: "UAP-AC-LR"})); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestConcurrentStateAccess(t *testing.T) {
+	defer newTestState(t)()
+	mac := [6]byte{0xf0, 0x9f, 0xc2, 0xaa, 0xbb, 0xcc}
+	haddr := hex.EncodeToString(mac[:])
+	localState.AccessPoints[haddr] = apState{Mac: mac, AuthKey: bytes.Repeat([]byte{0x42}, 16)}
+	a := &ap{HexAddr: haddr, MAddr: mac}
+
+	// Informs are handled concurrently with adoptions, which update the state.
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for n := 0; n < 20; n++ {
+				a.GetConfig()
+				a.GetConfigVersion()
+			}
+		}()
+		go func(i int) {
+			defer wg.Done()
+			for n := 0; n < 20; n++ {
+				a.SetState(manager.StateManaged)
+				a.SetConfigVersion(fmt.Sprint(i, n))
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	// Updates to forgotten APs are dropped, rather than adding the AP again.
+	a.Forget()
+	a.SetState(manager.StateManaged)
+	if _, known := localState.AccessPoints[haddr]; known {
+		t.Error("Expected forgotten AP to stay forgotten")
+	}
+}
@@ -1,26 +1,47 @@
 package main
 
 import (
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"gofi/config"
+	"gofi/manager"
+	"gofi/unf"
 	"io/ioutil"
 	"os"
 	"path"
+	"strings"
+	"sync"
 )
 
 type state struct {
 	AccessPoints map[string]apState
+	// Groups contains named configuration overrides, which APs can opt into by name.
+	Groups map[string]config.Override `json:",omitempty"`
+	// Patches are raw system.cfg changes applied to all APs, or all APs of a model.
+	Patches []config.Patch `json:",omitempty"`
 }
 
 type apState struct {
 	Mac           [6]byte
+	Model         string `json:",omitempty"`
 	State         int
 	ConfigVersion string
 	AuthKey       []byte
-	SSHPw         string
+	SSHUser       string `json:",omitempty"` // SSH and admin user of the device, defaults to ubnt.
+	SSHPw         string `json:",omitempty"` // Plaintext password, only present in statefiles which predate SSHPwEnc.
+	SSHPwEnc      []byte `json:",omitempty"` // SSH password, encrypted with the state key.
+	SSHPwHash     string `json:",omitempty"` // crypt(3) hash of SSHPwEnc, which is pushed to the device.
+	HostKey       string `json:",omitempty"` // Pinned SSH host key, in authorized_keys format.
+
+	Group     string          `json:",omitempty"`
+	Overrides config.Override // Per-AP overrides, taking precedence over the group.
+	Config    *config.Config  `json:",omitempty"` // Effective configuration, computed by resolving overrides.
 }
 
 var localState state
+var stateLock sync.Mutex // Guards localState and the statefile.
 var statePath string
 
 func loadConfig(p string) error {
@@ -44,9 +65,16 @@ func loadConfig(p string) error {
 		return nil
 	}
 
-	return json.Unmarshal(d, &localState)
+	if err := json.Unmarshal(d, &localState); err != nil {
+		return err
+	}
+	if localState.AccessPoints == nil {
+		localState.AccessPoints = map[string]apState{}
+	}
+	return nil
 }
 
+// flushConfig saves localState to the statefile. stateLock must be held.
 func flushConfig() {
 	b, err := json.Marshal(localState)
 	if err != nil {
@@ -59,3 +87,91 @@ func flushConfig() {
 		return
 	}
 }
+
+// repinHostKey forgets the pinned host key of the AP with the given MAC address.
+func repinHostKey(mac string) error {
+	haddr := strings.Replace(strings.ToLower(mac), ":", "", -1)
+	stateLock.Lock()
+	defer stateLock.Unlock()
+	ac, ok := localState.AccessPoints[haddr]
+	if !ok {
+		return errors.New("AP " + haddr + " not known")
+	}
+	ac.HostKey = ""
+	localState.AccessPoints[haddr] = ac
+	flushConfig()
+	fmt.Printf("Forgot pinned host key of %s, the next key presented will be trusted.\n", haddr)
+	return nil
+}
+
+// importDevice adds a device exported by another controller to the statefile. If the record has no
+// config version, the device is provisioned with our configuration when it first informs.
+func importDevice(r *manager.DeviceRecord) error {
+	mac, err := manager.ParseMAC(r.MAC)
+	if err != nil {
+		return err
+	}
+	key, err := r.Key()
+	if err != nil {
+		return err
+	}
+	haddr := hex.EncodeToString(mac[:])
+	stateLock.Lock()
+	defer stateLock.Unlock()
+	if _, exists := localState.AccessPoints[haddr]; exists {
+		return errors.New("AP " + haddr + " already known")
+	}
+
+	ac := apState{
+		Mac:           mac,
+		Model:         r.Model,
+		State:         manager.StateManaged,
+		ConfigVersion: r.ConfigVersion,
+		AuthKey:       key,
+		SSHUser:       r.SSHUser,
+		HostKey:       r.HostKey,
+	}
+	if ac.ConfigVersion == "" {
+		version, err := manager.GenerateRandomBytes(8)
+		if err != nil {
+			return err
+		}
+		ac.State = manager.StateAdopted
+		ac.ConfigVersion = hex.EncodeToString(version)
+	}
+	if r.SSHPw != "" {
+		if ac.SSHPwEnc, err = sealSecret(r.SSHPw); err != nil {
+			return err
+		}
+		if ac.SSHPwHash, err = config.HashPassword(r.SSHPw); err != nil {
+			return err
+		}
+	}
+	localState.AccessPoints[haddr] = ac
+	flushConfig()
+	fmt.Printf("Imported %s, it will be provisioned when it informs.\n", haddr)
+	return nil
+}
+
+// importBackup imports the adopted devices in a backup from the official UniFi controller.
+func importBackup(p string) error {
+	f, err := os.Open(p)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	devices, err := unf.ReadDevices(f)
+	if err != nil {
+		return err
+	}
+
+	for _, d := range devices {
+		fmt.Printf("Importing %s (%s, %s)\n", d.MAC, d.Model, d.Name)
+		// The device has the UniFi controller's configuration, so its config version is not kept, and
+		// it is provisioned with ours when it informs.
+		if err := importDevice(&manager.DeviceRecord{MAC: d.MAC, Model: d.Model, AuthKey: d.AuthKey, SSHUser: d.SSHUser, SSHPw: d.SSHPw}); err != nil {
+			fmt.Printf("Skipping %s: %v\n", d.MAC, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"gofi/manager"
+	"testing"
+)
+
+func TestImportDevice(t *testing.T) {
+	defer newTestState(t)()
+
+	if err := importDevice(&manager.DeviceRecord{MAC: "f0:9f:c2:aa:bb:cc", Model: "U7LR", AuthKey: "42424242424242424242424242424242", ConfigVersion: "abc", SSHUser: "admin", SSHPw: "hunter2"}); err != nil {
+		t.Fatal(err)
+	}
+	ac := localState.AccessPoints["f09fc2aabbcc"]
+	if ac.ConfigVersion != "abc" || ac.Model != "U7LR" || ac.State != manager.StateManaged || ac.SSHUser != "admin" {
+		t.Errorf("Unexpected state %+v", ac)
+	}
+	a := &ap{HexAddr: "f09fc2aabbcc", MAddr: ac.Mac}
+	if a.SSHUser() != "admin" || a.SSHPw() != "hunter2" || a.GetConfig().AdminUser != "admin" {
+		t.Errorf("Expected imported credentials to be used, got %s/%s", a.SSHUser(), a.SSHPw())
+	}
+	if err := importDevice(&manager.DeviceRecord{MAC: "f0:9f:c2:aa:bb:cc", AuthKey: "42424242424242424242424242424242"}); err == nil {
+		t.Error("Expected error importing known device")
+	}
+
+	// Devices without a config version are provisioned when they inform.
+	if err := importDevice(&manager.DeviceRecord{MAC: "f0:9f:c2:aa:bb:dd", AuthKey: "42424242424242424242424242424242"}); err != nil {
+		t.Fatal(err)
+	}
+	if ac := localState.AccessPoints["f09fc2aabbdd"]; ac.ConfigVersion == "" || ac.State != manager.StateAdopted {
+		t.Errorf("Unexpected state %+v", ac)
+	}
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"gofi/manager"
+	"gofi/packet"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// apInfo is the infoserv representation of an AP, combining its last inform with its controller state.
+type apInfo struct {
+	*packet.InformData
+	Alias string `json:"alias,omitempty"`
+	LED   string `json:"led_mode"`
+}
+
+// adoptRequest is the body of an /adopt request. The credentials are passed in the body rather than
+// the URL, so they don't end up in shell history or access logs.
+type adoptRequest struct {
+	IP   string `json:"ip"`
+	User string `json:"user,omitempty"` // Defaults to ubnt.
+	Pw   string `json:"pw"`
+}
+
+// infoserv returns the handler of the infoserv. Requests which change state or export secrets must
+// present the given token, see adminOnly.
+func infoserv(m *manager.Manager, token string) http.Handler {
+	h := http.NewServeMux()
+	h.HandleFunc("/", func(rw http.ResponseWriter, r *http.Request) {
+		out := map[string]apInfo{}
+		lastInformLock.Lock()
+		stateLock.Lock()
+		for mac, inform := range lastInformForMAC {
+			info := apInfo{InformData: inform, LED: ledSettings.String()}
+			if ac, ok := localState.AccessPoints[strings.Replace(strings.ToLower(mac), ":", "", -1)]; ok && ac.Config != nil {
+				info.Alias = ac.Config.Alias
+				info.LED = ac.Config.LED.String()
+			}
+			out[mac] = info
+		}
+		stateLock.Unlock()
+		lastInformLock.Unlock()
+
+		rw.Header().Set("Content-Type", "application/json")
+		e := json.NewEncoder(rw)
+		e.Encode(out)
+	})
+
+	h.HandleFunc("/pending", func(rw http.ResponseWriter, r *http.Request) {
+		rw.Header().Set("Content-Type", "application/json")
+		e := json.NewEncoder(rw)
+		e.Encode(m.PendingDevices())
+	})
+	h.HandleFunc("/adoptions", func(rw http.ResponseWriter, r *http.Request) {
+		rw.Header().Set("Content-Type", "application/json")
+		e := json.NewEncoder(rw)
+		e.Encode(m.AdoptionStatuses())
+	})
+	h.HandleFunc("/inform_urls", func(rw http.ResponseWriter, r *http.Request) {
+		rw.Header().Set("Content-Type", "application/json")
+		e := json.NewEncoder(rw)
+		e.Encode(m.InformURLProblems())
+	})
+	h.HandleFunc("/scan", adminOnly(token, func(rw http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		if err := m.Scan(r.Form["target"]...); err != nil {
+			http.Error(rw, err.Error(), http.StatusBadRequest)
+			return
+		}
+		io.WriteString(rw, "ok\n")
+	}))
+	h.HandleFunc("/approve", adminOnly(token, func(rw http.ResponseWriter, r *http.Request) {
+		mac, ok := macFromRequest(rw, r)
+		if !ok {
+			return
+		}
+		if err := m.Approve(mac); err != nil {
+			http.Error(rw, err.Error(), http.StatusNotFound)
+			return
+		}
+		io.WriteString(rw, "ok\n")
+	}))
+	h.HandleFunc("/adopt", adminOnly(token, func(rw http.ResponseWriter, r *http.Request) {
+		var req adoptRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(rw, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if req.User == "" {
+			req.User = "ubnt"
+		}
+		if err := m.AdoptAt(req.IP, req.User, req.Pw); err != nil {
+			http.Error(rw, err.Error(), http.StatusBadGateway)
+			return
+		}
+		io.WriteString(rw, "ok\n")
+	}))
+	h.HandleFunc("/adopt/hints", func(rw http.ResponseWriter, r *http.Request) {
+		hints, err := m.RemoteAdoptionHints()
+		if err != nil {
+			http.Error(rw, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		rw.Header().Set("Content-Type", "application/json")
+		e := json.NewEncoder(rw)
+		e.Encode(hints)
+	})
+	h.HandleFunc("/export", adminOnly(token, func(rw http.ResponseWriter, r *http.Request) {
+		mac, ok := macFromRequest(rw, r)
+		if !ok {
+			return
+		}
+		record, err := m.ExportDevice(mac)
+		if err != nil {
+			http.Error(rw, err.Error(), http.StatusNotFound)
+			return
+		}
+		rw.Header().Set("Content-Type", "application/json")
+		e := json.NewEncoder(rw)
+		e.Encode(record)
+	}))
+	h.HandleFunc("/import", adminOnly(token, func(rw http.ResponseWriter, r *http.Request) {
+		var record manager.DeviceRecord
+		if err := json.NewDecoder(r.Body).Decode(&record); err != nil {
+			http.Error(rw, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := importDevice(&record); err != nil {
+			http.Error(rw, err.Error(), http.StatusBadRequest)
+			return
+		}
+		io.WriteString(rw, "ok\n")
+	}))
+	h.HandleFunc("/migrate", adminOnly(token, func(rw http.ResponseWriter, r *http.Request) {
+		mac, ok := macFromRequest(rw, r)
+		if !ok {
+			return
+		}
+		if err := m.Migrate(mac, r.FormValue("url")); err != nil {
+			http.Error(rw, err.Error(), http.StatusBadRequest)
+			return
+		}
+		io.WriteString(rw, "ok\n")
+	}))
+	h.HandleFunc("/forget", adminOnly(token, func(rw http.ResponseWriter, r *http.Request) {
+		mac, ok := macFromRequest(rw, r)
+		if !ok {
+			return
+		}
+		if err := m.Forget(mac); err != nil {
+			http.Error(rw, err.Error(), http.StatusNotFound)
+			return
+		}
+		io.WriteString(rw, "ok\n")
+	}))
+	return h
+}
+
+// adminOnly wraps a handler which changes state or exports secrets. Requests must be POSTs with the
+// token in the Authorization header ("Bearer <token>"), and must not have a form content type, as
+// browsers send those to other sites without asking first. Parameters can be passed in the URL.
+func adminOnly(token string, next http.HandlerFunc) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(rw, "POST required", http.StatusMethodNotAllowed)
+			return
+		}
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte("Bearer "+token)) != 1 {
+			http.Error(rw, "invalid or missing token", http.StatusUnauthorized)
+			return
+		}
+		if ct := r.Header.Get("Content-Type"); ct != "" {
+			mediaType, _, err := mime.ParseMediaType(ct)
+			if err != nil || mediaType == "application/x-www-form-urlencoded" || mediaType == "multipart/form-data" || mediaType == "text/plain" {
+				http.Error(rw, "unsupported content type "+ct, http.StatusUnsupportedMediaType)
+				return
+			}
+		}
+		next(rw, r)
+	}
+}
+
+// macFromRequest parses the mac parameter of a request, writing an error response if it is invalid.
+func macFromRequest(rw http.ResponseWriter, r *http.Request) ([6]byte, bool) {
+	mac, err := manager.ParseMAC(r.FormValue("mac"))
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return [6]byte{}, false
+	}
+	return mac, true
+}
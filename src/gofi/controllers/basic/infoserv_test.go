@@ -0,0 +1,65 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAdminOnly(t *testing.T) {
+	h := adminOnly("s3cret", func(rw http.ResponseWriter, r *http.Request) {
+		if r.FormValue("mac") != "f0:9f:c2:aa:bb:cc" {
+			t.Errorf("Unexpected mac %q", r.FormValue("mac"))
+		}
+	})
+
+	for _, tc := range []struct {
+		name, method, auth, contentType, body string
+		expected                              int
+	}{
+		{"ok", "POST", "Bearer s3cret", "", "", http.StatusOK},
+		{"json", "POST", "Bearer s3cret", "application/json", "{}", http.StatusOK},
+		{"get", "GET", "Bearer s3cret", "", "", http.StatusMethodNotAllowed},
+		{"no token", "POST", "", "", "", http.StatusUnauthorized},
+		{"wrong token", "POST", "Bearer s3cre", "", "", http.StatusUnauthorized},
+		{"form", "POST", "Bearer s3cret", "application/x-www-form-urlencoded", "mac=f0:9f:c2:aa:bb:cc", http.StatusUnsupportedMediaType},
+		{"multipart", "POST", "Bearer s3cret", "multipart/form-data; boundary=x", "", http.StatusUnsupportedMediaType},
+		{"text", "POST", "Bearer s3cret", "text/plain;charset=UTF-8", "", http.StatusUnsupportedMediaType},
+	} {
+		r := httptest.NewRequest(tc.method, "/approve?mac=f0:9f:c2:aa:bb:cc", strings.NewReader(tc.body))
+		if tc.auth != "" {
+			r.Header.Set("Authorization", tc.auth)
+		}
+		if tc.contentType != "" {
+			r.Header.Set("Content-Type", tc.contentType)
+		}
+		rw := httptest.NewRecorder()
+		h(rw, r)
+		if rw.Code != tc.expected {
+			t.Errorf("%s: expected status %d, got %d", tc.name, tc.expected, rw.Code)
+		}
+	}
+}
+
+func TestAdoptRequest(t *testing.T) {
+	h := infoserv(nil, "s3cret")
+	for _, tc := range []struct {
+		name, url, body string
+		expected        int
+		errText         string
+	}{
+		{"json", "/adopt", `{"ip":"bogus","pw":"ubnt"}`, http.StatusBadGateway, "invalid IP address bogus"},
+		{"query", "/adopt?ip=bogus&pw=ubnt", "", http.StatusBadRequest, "EOF"},
+		{"invalid json", "/adopt", `{"ip":`, http.StatusBadRequest, "unexpected EOF"},
+	} {
+		r := httptest.NewRequest("POST", tc.url, strings.NewReader(tc.body))
+		r.Header.Set("Authorization", "Bearer s3cret")
+		r.Header.Set("Content-Type", "application/json")
+		rw := httptest.NewRecorder()
+		h.ServeHTTP(rw, r)
+		if rw.Code != tc.expected || !strings.Contains(rw.Body.String(), tc.errText) {
+			t.Errorf("%s: expected status %d with %q, got %d %q", tc.name, tc.expected, tc.errText, rw.Code, rw.Body.String())
+		}
+	}
+}
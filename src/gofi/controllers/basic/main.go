@@ -1,14 +1,16 @@
 package main
 
 import (
-	"encoding/json"
 	"flag"
 	"fmt"
+	"gofi/config"
 	"gofi/manager"
 	"gofi/packet"
 	"log"
 	"net/http"
 	"os"
+	"strings"
+	"sync"
 )
 
 var ssid = flag.String("ssid", "gofi", "Network name")
@@ -17,64 +19,119 @@ var do5G = flag.Bool("enable_5g", true, "Make network available on 5G as well as
 var bandSteer = flag.Bool("enable_bandsteering", false, "Steer clients to 5G network")
 var txPower = flag.Int("tx", 0, "(optional) TX power in DB, defaults to auto")
 var minRSSI = flag.Int("min_rssi", 0, "(optional) Station RSSI at which it is deauthed, defaults to disabled")
-var localAddress = flag.String("addr", "", "(optional) Controller LAN IP - autodetected if not set")
+var airtimeFairness = flag.Bool("airtime_fairness", false, "Enable airtime fairness on both radios")
+var rateLimitUp = flag.Int("rate_limit_up", 0, "(optional) Upload limit for the network in Kbps, defaults to unlimited")
+var rateLimitDown = flag.Int("rate_limit_down", 0, "(optional) Download limit for the network in Kbps, defaults to unlimited")
+var rateLimitPerClient = flag.Bool("rate_limit_per_client", false, "Apply rate limits to each client, rather than all clients combined")
+var minRate = flag.Int("min_rate", 0, "(optional) Minimum basic data rate in Kbps, 6000 or higher disables 802.11b rates. Defaults to firmware default")
+var leds = flag.String("leds", "on", "LED mode of the APs: on, off, or night:<start hour>-<end hour> to turn them off at night")
+var autoApprove = flag.String("auto_approve", "", "Comma-separated MAC address patterns (ie: f0:9f:c2:*) of devices which are adopted without approval")
+var maxInformSize = flag.Int("max_inform_size", packet.MaxPayloadSize, "Largest inform payload accepted, in bytes")
+var informListener = flag.String("inform_listener", ":8421", "Address to listen for informs on. Devices which find the controller via DHCP option 43 or DNS inform on port 8080")
+var discoveryIfaces = flag.String("discovery_interfaces", "", "(optional) Comma-separated interfaces to discover devices on, defaults to all")
+var localAddress = flag.String("addr", "", "(optional) Controller LAN IP - chosen for each AP from the route to it if not set")
 var configPath = flag.String("statefile", "", "Path to location to store state")
+var stateKeyPath = flag.String("statekey", "", "Path to the key used to encrypt credentials in the statefile, defaults to the statefile path + .key")
+var sshKeysPath = flag.String("ssh_authorized_keys", "", "(optional) Path to an authorized_keys file, which is installed on all APs")
+var repin = flag.String("repin", "", "(optional) MAC address of an AP whose pinned SSH host key should be forgotten, ie: after replacing hardware")
+var importUNF = flag.String("import_unf", "", "(optional) Path to a backup (.unf) from the official UniFi controller, whose adopted devices are imported")
 var infoServer = flag.String("infoserv", "", "Address to host the infoserv at. Infoserv disabled if not provided.")
+var infoServerToken = flag.String("infoserv_token", "", "Path to the token required to make changes via the infoserv, defaults to the statefile path + .token")
 
 var lastInformForMAC map[string]*packet.InformData
+var lastInformLock sync.Mutex // Guards lastInformForMAC.
+
+var ledSettings config.LEDSettings
+var authorizedKeys []string
 
 func main() {
 	lastInformForMAC = map[string]*packet.InformData{}
 	flag.Parse()
+	packet.MaxPayloadSize = *maxInformSize
+	var err error
+	if ledSettings, err = config.ParseLEDSettings(*leds); err != nil {
+		fmt.Println("Error:", err)
+		os.Exit(1)
+	}
 
 	errLoad := loadConfig(*configPath)
 	if errLoad != nil {
 		fmt.Println("Error:", errLoad)
 		os.Exit(1)
 	}
+	if err := loadStateKey(*stateKeyPath); err != nil {
+		fmt.Println("Error loading state key:", err)
+		os.Exit(1)
+	}
+	var infoservToken string
+	if *infoServer != "" {
+		if infoservToken, err = loadInfoservToken(*infoServerToken); err != nil {
+			fmt.Println("Error loading infoserv token:", err)
+			os.Exit(1)
+		}
+	}
+	if *repin != "" {
+		if err := repinHostKey(*repin); err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(1)
+		}
+	}
+	if *importUNF != "" {
+		if err := importBackup(*importUNF); err != nil {
+			fmt.Println("Error importing backup:", err)
+			os.Exit(1)
+		}
+	}
+	if *sshKeysPath != "" {
+		if authorizedKeys, err = loadAuthorizedKeys(*sshKeysPath); err != nil {
+			fmt.Println("Error loading authorized keys:", err)
+			os.Exit(1)
+		}
+	}
 
-	if *bandSteer && !*do5G {
-		fmt.Println("Error: Cannot bandsteer without 5G networks enabled")
+	if err := siteConfig().Validate(); err != nil {
+		fmt.Println("Error:", err)
 		os.Exit(1)
 	}
 
 	controllerAddr := *localAddress
 	if controllerAddr == "" {
-		laddr, err := localAddr()
-		if err != nil {
-			log.Println("Error fetching local address:", err)
-			os.Exit(1)
-		}
-		controllerAddr = laddr.String()
+		log.Println("Controller address will be chosen for each AP")
+	} else {
+		log.Printf("Controller will run on %s\n", controllerAddr)
 	}
-
-	log.Printf("Controller will run on %s\n", controllerAddr)
 	informChan := make(chan *packet.InformData, 5)
 	go func() {
 		for i := range informChan {
+			lastInformLock.Lock()
 			lastInformForMAC[i.Mac] = i
+			lastInformLock.Unlock()
 		}
 	}()
 
+	manager, err := manager.New(*informListener, controllerAddr, nil, onDiscoveryPacket, onControllerDoesntKnowAP, informChan, splitList(*discoveryIfaces)...)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	defer manager.Close()
+	if *autoApprove != "" {
+		if err := manager.SetAutoApprove(strings.Split(*autoApprove, ",")); err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(1)
+		}
+	}
+
+	if *infoServer == "" && *autoApprove == "" {
+		fmt.Println("Warning: new devices cannot be approved for adoption without -infoserv or -auto_approve")
+	}
 	if *infoServer != "" {
 		fmt.Println("Infoserver will run on", *infoServer)
-		h := http.NewServeMux()
-		h.HandleFunc("/", func(rw http.ResponseWriter, r *http.Request) {
-			rw.Header().Set("Content-Type", "application/json")
-			e := json.NewEncoder(rw)
-			e.Encode(lastInformForMAC)
-		})
 		go func() {
-			fmt.Println(http.ListenAndServe(*infoServer, h))
+			fmt.Println(http.ListenAndServe(*infoServer, infoserv(manager, infoservToken)))
 		}()
 	}
 
-	manager, err := manager.New(":8421", controllerAddr, nil, onDiscoveryPacket, onControllerDoesntKnowAP, informChan)
-	if err != nil {
-		fmt.Println(err)
-		os.Exit(1)
-	}
-	defer manager.Close()
 	err = manager.Run()
 	if err != nil {
 		fmt.Println("Error starting manager: ", err)
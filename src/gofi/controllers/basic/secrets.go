@@ -0,0 +1,120 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/hex"
+	"errors"
+	"gofi/manager"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// stateKey is used to encrypt secrets (such as device credentials) before they are written to the statefile.
+var stateKey []byte
+
+// loadStateKey reads the state encryption key from the given path, generating a new key if none exists.
+// If no path is specified, the key is stored alongside the statefile.
+func loadStateKey(p string) error {
+	if p == "" {
+		p = statePath + ".key"
+	}
+
+	d, err := ioutil.ReadFile(p)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if os.IsNotExist(err) {
+		if stateKey, err = manager.GenerateRandomBytes(32); err != nil {
+			return err
+		}
+		return ioutil.WriteFile(p, []byte(hex.EncodeToString(stateKey)+"\n"), 0600)
+	}
+
+	stateKey, err = hex.DecodeString(strings.TrimSpace(string(d)))
+	if err != nil {
+		return err
+	}
+	if len(stateKey) != 32 {
+		return errors.New("state key must be 32 bytes")
+	}
+	return nil
+}
+
+// loadInfoservToken reads the token which authorizes changes via the infoserv from the given path,
+// generating a new token if none exists. If no path is specified, the token is stored alongside the statefile.
+func loadInfoservToken(p string) (string, error) {
+	if p == "" {
+		p = statePath + ".token"
+	}
+
+	d, err := ioutil.ReadFile(p)
+	if err != nil && !os.IsNotExist(err) {
+		return "", err
+	}
+	if os.IsNotExist(err) {
+		token, err := manager.GeneratePassword()
+		if err != nil {
+			return "", err
+		}
+		return token, ioutil.WriteFile(p, []byte(token+"\n"), 0600)
+	}
+
+	token := strings.TrimSpace(string(d))
+	if token == "" {
+		return "", errors.New("infoserv token is empty")
+	}
+	return token, nil
+}
+
+func stateCipher() (cipher.AEAD, error) {
+	block, err := aes.NewCipher(stateKey)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// sealSecret encrypts a secret with the state key.
+func sealSecret(secret string) ([]byte, error) {
+	aead, err := stateCipher()
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := manager.GenerateRandomBytes(aead.NonceSize())
+	if err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, []byte(secret), nil), nil
+}
+
+// openSecret decrypts a secret encrypted by sealSecret.
+func openSecret(sealed []byte) (string, error) {
+	aead, err := stateCipher()
+	if err != nil {
+		return "", err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return "", errors.New("sealed secret too short")
+	}
+	d, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], nil)
+	return string(d), err
+}
+
+// loadAuthorizedKeys reads SSH public keys from a file in authorized_keys format.
+func loadAuthorizedKeys(p string) ([]string, error) {
+	d, err := ioutil.ReadFile(p)
+	if err != nil {
+		return nil, err
+	}
+	var out []string
+	for _, line := range strings.Split(string(d), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		out = append(out, line)
+	}
+	return out, nil
+}
@@ -7,6 +7,7 @@ import (
 	"gofi/manager"
 	"log"
 	"os"
+	"strings"
 )
 
 var ssid = flag.String("ssid", "gofi", "Network name")
@@ -15,31 +16,44 @@ var do5G = flag.Bool("enable_5g", true, "Make network available on 5G as well as
 var bandSteer = flag.Bool("enable_bandsteering", false, "Steer clients to 5G network")
 var txPower = flag.Int("tx", 0, "(optional) TX power in DB, defaults to auto")
 var minRSSI = flag.Int("min_rssi", 0, "(optional) Station RSSI at which it is deauthed, defaults to disabled")
-var localAddress = flag.String("addr", "", "Controller LAN IP - autodetected if not set")
+var airtimeFairness = flag.Bool("airtime_fairness", false, "Enable airtime fairness on both radios")
+var rateLimitUp = flag.Int("rate_limit_up", 0, "(optional) Upload limit for the network in Kbps, defaults to unlimited")
+var rateLimitDown = flag.Int("rate_limit_down", 0, "(optional) Download limit for the network in Kbps, defaults to unlimited")
+var rateLimitPerClient = flag.Bool("rate_limit_per_client", false, "Apply rate limits to each client, rather than all clients combined")
+var minRate = flag.Int("min_rate", 0, "(optional) Minimum basic data rate in Kbps, 6000 or higher disables 802.11b rates. Defaults to firmware default")
+var leds = flag.String("leds", "on", "LED mode of the APs: on, off, or night:<start hour>-<end hour> to turn them off at night")
+var autoApprove = flag.String("auto_approve", "", "Comma-separated MAC address patterns (ie: f0:9f:c2:*) of devices to adopt, or * for all devices in range. Required, as devices cannot be approved otherwise")
+var discoveryIfaces = flag.String("discovery_interfaces", "", "(optional) Comma-separated interfaces to discover devices on, defaults to all")
+var localAddress = flag.String("addr", "", "Controller LAN IP - chosen for each AP from the route to it if not set")
+
+var ledSettings config.LEDSettings
 
 func main() {
 	flag.Parse()
-	if *bandSteer && !*do5G {
-		fmt.Println("Error: Cannot bandsteer without 5G networks enabled")
+	if *autoApprove == "" {
+		fmt.Println("Error: -auto_approve is required. Pass -auto_approve='*' to adopt every device in range.")
+		os.Exit(1)
+	}
+	var err error
+	if ledSettings, err = config.ParseLEDSettings(*leds); err != nil {
+		fmt.Println("Error:", err)
 		os.Exit(1)
 	}
 
 	controllerAddr := *localAddress
 	if controllerAddr == "" {
-		laddr, err := localAddr()
-		if err != nil {
-			log.Println("Error fetching local address:", err)
-			os.Exit(1)
-		}
-		controllerAddr = laddr.String()
+		log.Println("Controller address will be chosen for each AP")
+	} else {
+		log.Printf("Controller will run on %s\n", controllerAddr)
 	}
-
-	log.Printf("Controller will run on %s\n", controllerAddr)
 	c := &config.Config{
 		Networks: []config.Network{
 			config.Network{
-				SSID: *ssid,
-				Pass: *password,
+				SSID:               *ssid,
+				Pass:               *password,
+				RateLimitUpKbps:    *rateLimitUp,
+				RateLimitDownKbps:  *rateLimitDown,
+				RateLimitPerClient: *rateLimitPerClient,
 			},
 		},
 		Bandsteer: config.SteerSettings{
@@ -48,22 +62,35 @@ func main() {
 		},
 		Txpower: *txPower,
 		MinRSSI: *minRSSI,
+		LED:     ledSettings,
+		Radio2G: config.RadioSettings{
+			AirtimeFairness:  *airtimeFairness,
+			MinBasicRateKbps: *minRate,
+		},
+		Radio5G: config.RadioSettings{
+			AirtimeFairness:  *airtimeFairness,
+			MinBasicRateKbps: *minRate,
+		},
 	}
 
 	if *do5G {
-		c.Networks = append(c.Networks, config.Network{
-			SSID:   *ssid,
-			Pass:   *password,
-			Is5Ghz: true,
-		})
+		c.Networks[0].Band = config.BandBoth
+	}
+	if err := c.Validate(); err != nil {
+		fmt.Println("Error:", err)
+		os.Exit(1)
 	}
 
-	manager, err := manager.New(":8421", controllerAddr, c, nil, nil, nil)
+	manager, err := manager.New(":8421", controllerAddr, c, nil, nil, nil, splitList(*discoveryIfaces)...)
 	if err != nil {
 		fmt.Println(err)
 		os.Exit(1)
 	}
 	defer manager.Close()
+	if err := manager.SetAutoApprove(strings.Split(*autoApprove, ",")); err != nil {
+		fmt.Println("Error:", err)
+		os.Exit(1)
+	}
 	err = manager.Run()
 	if err != nil {
 		fmt.Println("Error starting manager: ", err)
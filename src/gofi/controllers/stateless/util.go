@@ -1,38 +1,20 @@
 package main
 
 import (
-	"errors"
 	"fmt"
-	"net"
 	"os"
+	"strings"
 )
 
-func localAddr() (net.IP, error) {
-	ifaces, err := net.Interfaces()
-
-	if err != nil {
-		return nil, err
-	}
-	for _, i := range ifaces {
-		addrs, err := i.Addrs()
-		if err != nil {
-			return nil, err
-		}
-		for _, addr := range addrs {
-			var ip net.IP
-			switch v := addr.(type) {
-			case *net.IPNet:
-				ip = v.IP
-			case *net.IPAddr:
-				ip = v.IP
-			}
-			// process IP address
-			if !ip.IsLoopback() {
-				return ip, nil
-			}
+// splitList splits a comma-separated flag value, returning nil if it is empty.
+func splitList(s string) []string {
+	var out []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			out = append(out, v)
 		}
 	}
-	return nil, errors.New("Could not find appropriate interface")
+	return out
 }
 
 // CheckError terminates the program if the error is non nil
@@ -0,0 +1,76 @@
+package manager
+
+import (
+	"errors"
+	"fmt"
+	"gofi/packet"
+)
+
+// QueueAction queues an action to send to an AP when it next informs. The actions, and the fields
+// of APAction they use, are:
+//
+//	reboot, locate, unset-locate
+//	factory-reset: resets the AP, which is kept, and re-adopted once approved again (see Forget to remove it)
+//	upgrade: URL and MD5Sum of the firmware, and optionally Version
+//	kick, block-sta, unblock-sta, unauthorize-guest: StationMac
+//	authorize-guest: StationMac, Minutes, and optionally UpKbps, DownKbps and QuotaMB
+//	spectrum-scan: Radio (ng or na), or all radios if empty
+//	cable-test, power-cycle: Port of a switch
+//	set-inform: InformURL, see Migrate
+//	set-default: see Forget
+func (m *Manager) QueueAction(mac [6]byte, action *APAction) error {
+	switch action.Action {
+	case "set-inform":
+		return m.Migrate(mac, action.InformURL)
+	case "set-default":
+		return m.Forget(mac)
+	}
+
+	m.apLock.Lock()
+	defer m.apLock.Unlock()
+	if m.MacAddrToKey[mac] == nil {
+		return errors.New("no such AP")
+	}
+	if _, err := actionCommand(action); err != nil {
+		return err
+	}
+	if m.queuedActions[mac] != nil {
+		return errors.New("a queued event already exists")
+	}
+	m.queuedActions[mac] = action
+	fmt.Printf("[MANAGER] [%x] Queued %s\n", mac, action.Action)
+	return nil
+}
+
+// actionCommand returns the payload for actions which do not depend on the AP's configuration.
+func actionCommand(action *APAction) ([]byte, error) {
+	switch action.Action {
+	case "reboot":
+		return packet.MakeReboot()
+	case "locate":
+		return packet.MakeLocate()
+	case "unset-locate":
+		return packet.MakeUnsetLocate()
+	case "factory-reset":
+		return packet.MakeSetDefault()
+	case "upgrade":
+		return packet.MakeUpgrade(action.URL, action.MD5Sum, action.Version)
+	case "kick":
+		return packet.MakeKickStation(action.StationMac)
+	case "block-sta":
+		return packet.MakeBlockStation(action.StationMac)
+	case "unblock-sta":
+		return packet.MakeUnblockStation(action.StationMac)
+	case "authorize-guest":
+		return packet.MakeAuthorizeGuest(action.StationMac, action.Minutes, action.UpKbps, action.DownKbps, action.QuotaMB)
+	case "unauthorize-guest":
+		return packet.MakeUnauthorizeGuest(action.StationMac)
+	case "spectrum-scan":
+		return packet.MakeSpectrumScan(action.Radio)
+	case "cable-test":
+		return packet.MakeCableTest(action.Port)
+	case "power-cycle":
+		return packet.MakePowerCyclePort(action.Port)
+	}
+	return nil, fmt.Errorf("unknown action: %s", action.Action)
+}
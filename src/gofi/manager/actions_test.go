@@ -0,0 +1,115 @@
+package manager
+
+import (
+	"bytes"
+	"gofi/config"
+	"gofi/packet"
+	"testing"
+)
+
+func TestQueueAction(t *testing.T) {
+	mac := testMAC
+	sta := [6]byte{0x80, 0x2a, 0xa8, 0x11, 0x22, 0x33}
+	ap := &BasicClient{MACAddr: mac, EncryptionKey: testKey}
+	m := newTestManager(t, ap)
+
+	for _, tc := range []struct {
+		action   APAction
+		expected packet.CommandData
+	}{
+		{APAction{Action: "reboot"}, packet.CommandData{Type: "cmd", Cmd: "reboot"}},
+		{APAction{Action: "unset-locate"}, packet.CommandData{Type: "cmd", Cmd: "unset-locate"}},
+		{APAction{Action: "factory-reset"}, packet.CommandData{Type: "cmd", Cmd: "set-default"}},
+		{APAction{Action: "upgrade", URL: "http://10.0.0.2/fw.bin", MD5Sum: "8b3c9d0e0a9f6e3f1b2a3c4d5e6f7a8b"},
+			packet.CommandData{Type: "upgrade", URL: "http://10.0.0.2/fw.bin", MD5Sum: "8b3c9d0e0a9f6e3f1b2a3c4d5e6f7a8b"}},
+		{APAction{Action: "authorize-guest", StationMac: sta, Minutes: 30, DownKbps: 2000},
+			packet.CommandData{Type: "cmd", Cmd: "authorize-guest", MacAddr: "80:2a:a8:11:22:33", Minutes: 30, DownKbps: 2000}},
+		{APAction{Action: "unauthorize-guest", StationMac: sta}, packet.CommandData{Type: "cmd", Cmd: "unauthorize-guest", MacAddr: "80:2a:a8:11:22:33"}},
+		{APAction{Action: "block-sta", StationMac: sta}, packet.CommandData{Type: "cmd", Cmd: "block-sta", MacAddr: "80:2a:a8:11:22:33"}},
+		{APAction{Action: "unblock-sta", StationMac: sta}, packet.CommandData{Type: "cmd", Cmd: "unblock-sta", MacAddr: "80:2a:a8:11:22:33"}},
+		{APAction{Action: "spectrum-scan"}, packet.CommandData{Type: "cmd", Cmd: "spectrum-scan"}},
+		{APAction{Action: "cable-test", Port: 2}, packet.CommandData{Type: "cmd", Cmd: "cable-test", Port: 2}},
+		{APAction{Action: "power-cycle", Port: 5}, packet.CommandData{Type: "cmd", Cmd: "power-cycle", Port: 5}},
+	} {
+		action := tc.action
+		if err := m.QueueAction(mac, &action); err != nil {
+			t.Fatalf("%s: %v", tc.action.Action, err)
+		}
+		if err := m.QueueAction(mac, &APAction{Action: "reboot"}); err == nil {
+			t.Errorf("%s: expected error queueing a second action", tc.action.Action)
+		}
+
+		out, err := m.handleNormalInform(nil, newTestInform(mac), ap, nil)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.action.Action, err)
+		}
+		if cmd := decodeReply(t, out, testKey); cmd != tc.expected {
+			t.Errorf("%s: expected %+v, got %+v", tc.action.Action, tc.expected, cmd)
+		}
+		if m.queuedActions[mac] != nil {
+			t.Errorf("%s: expected action to be dequeued", tc.action.Action)
+		}
+	}
+	if _, known := m.MacAddrToKey[mac]; !known {
+		t.Error("Factory reset should not forget the AP")
+	}
+}
+
+func TestFactoryReset(t *testing.T) {
+	// Queued actions are sent once the AP is provisioned, so the LEDs are left alone.
+	conf := nightLEDConfig()
+	conf.LED = config.LEDSettings{}
+	ap := &BasicClient{MACAddr: testMAC, EncryptionKey: testKey, CfgVersion: "abc", Configuration: conf}
+	m := newTestManager(t, ap)
+	m.approved[testMAC] = true
+	if err := m.QueueAction(testMAC, &APAction{Action: "factory-reset"}); err != nil {
+		t.Fatal(err)
+	}
+	out, err := m.HandleInform("192.168.1.20:41234", encodeInform(t, testMAC, testKey, &packet.InformData{ModelName: "UAP-AC-LR", ConfigVersion: "abc"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cmd := decodeReply(t, out, testKey); cmd.Cmd != "set-default" {
+		t.Fatalf("Expected set-default command, got %+v", cmd)
+	}
+
+	// Once reset, the AP informs with the default key, and waits for approval.
+	defaultInform := &packet.InformData{ModelName: "UAP-AC-LR", Model: "U7LR", IsDefaultConfig: true}
+	if _, err := m.HandleInform("192.168.1.20:41234", encodeInform(t, testMAC, packet.DefaultKey, defaultInform)); err == nil {
+		t.Error("Expected error for reset AP awaiting approval")
+	}
+	if m.lookupAP(testMAC) != ap || len(m.PendingDevices()) != 1 {
+		t.Fatal("Expected reset AP to be kept, and await approval")
+	}
+	if err := m.Approve(testMAC); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.HandleInform("192.168.1.20:41234", encodeInform(t, testMAC, packet.DefaultKey, defaultInform)); err != nil {
+		t.Fatal(err)
+	}
+	if readopted := m.lookupAP(testMAC); readopted == nil || bytes.Equal(readopted.AuthKey(), testKey) {
+		t.Error("Expected AP to be re-adopted with a new key once approved")
+	}
+}
+
+func TestQueueActionRejectsInvalid(t *testing.T) {
+	mac := testMAC
+	m := newTestManager(t, &BasicClient{MACAddr: mac})
+	for _, action := range []APAction{
+		{Action: "self-destruct"},
+		{Action: "upgrade", URL: "http://10.0.0.2/fw.bin"},
+		{Action: "authorize-guest"},
+		{Action: "cable-test"},
+		{Action: "set-inform", InformURL: "10.0.0.2:8080"},
+	} {
+		if err := m.QueueAction(mac, &action); err == nil {
+			t.Errorf("Expected error queueing %+v", action)
+		}
+	}
+	if err := m.QueueAction([6]byte{1, 2, 3, 4, 5, 6}, &APAction{Action: "reboot"}); err == nil {
+		t.Error("Expected error queueing action for unknown AP")
+	}
+	if len(m.queuedActions) != 0 {
+		t.Errorf("Unexpected queued actions %+v", m.queuedActions)
+	}
+}
@@ -0,0 +1,169 @@
+package manager
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// InformURLProblem describes an AP whose inform URL points at an address it probably cannot reach.
+// Such APs are only informing because firmware falls back to other addresses, which is slow and
+// stops working if the fallback does.
+type InformURLProblem struct {
+	MAC       string
+	IP        string
+	InformURL string
+	Expected  string // Controller address the AP should inform to.
+	Reason    string
+	LastSeen  time.Time
+}
+
+// Host returns the host part of addr, which is either a host:port pair or just a host. IPv6
+// addresses are returned without brackets.
+func Host(addr string) string {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return strings.Trim(addr, "[]")
+}
+
+// routeAddr returns the local address the system routes packets to ip from. No packets are sent.
+func routeAddr(ip net.IP) (net.IP, error) {
+	conn, err := net.DialUDP("udp", nil, &net.UDPAddr{IP: ip, Port: 10001})
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	return conn.LocalAddr().(*net.UDPAddr).IP, nil
+}
+
+// ifaceAddrFor returns the address of the named interface which is on the same subnet as ip.
+func ifaceAddrFor(name string, ip net.IP) net.IP {
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return nil
+	}
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil
+	}
+	for _, a := range addrs {
+		if subnet, ok := a.(*net.IPNet); ok && subnet.Contains(ip) {
+			return subnet.IP
+		}
+	}
+	return nil
+}
+
+// controllerAddrFor returns the address APs at apIP should inform to. If the controller address was
+// given to New it is always used, otherwise the address is chosen from the interface the AP was
+// discovered on (if known), or the route to the AP.
+func (m *Manager) controllerAddrFor(apIP net.IP, iface string) string {
+	if m.localAddr != "" {
+		return m.localAddr
+	}
+	if apIP != nil && iface != "" {
+		if ip := ifaceAddrFor(iface, apIP); ip != nil {
+			return ip.String()
+		}
+	}
+	if apIP == nil || apIP.IsUnspecified() {
+		// Use the address of the default route, preferring IPv4.
+		if ip, err := routeAddr(net.IPv4(192, 0, 2, 1)); err == nil {
+			return ip.String()
+		}
+		apIP = net.ParseIP("2001:db8::1")
+	}
+	ip, err := routeAddr(apIP)
+	if err != nil {
+		fmt.Printf("[MANAGER] Could not find a route to %s: %s\n", apIP, err)
+		return ""
+	}
+	return ip.String()
+}
+
+// controllerAddrForRemote is controllerAddrFor for an AP at the given host:port.
+func (m *Manager) controllerAddrForRemote(remoteAddr string) string {
+	return m.controllerAddrFor(net.ParseIP(Host(remoteAddr)), "")
+}
+
+// informURLProblem returns a description of why an AP at apIP probably cannot reach informURL,
+// or the empty string if it can, or it cannot be determined.
+func informURLProblem(informURL, expected string, apIP net.IP, localSubnets []*net.IPNet) string {
+	u, err := url.Parse(informURL)
+	if err != nil || u.Host == "" {
+		return "invalid inform URL"
+	}
+	ip := net.ParseIP(u.Hostname())
+	if ip == nil {
+		return "" // Hostnames are resolved by the AP, so may well be reachable.
+	}
+	if ip.String() == expected {
+		return ""
+	}
+	for _, subnet := range localSubnets {
+		if subnet.IP.Equal(ip) {
+			if apIP != nil && subnet.Contains(apIP) {
+				return "" // The AP is on the same subnet as the address.
+			}
+			return fmt.Sprintf("%s is on a network the AP is not connected to, it reaches the controller via %s", ip, expected)
+		}
+	}
+	if ip.IsLoopback() {
+		return fmt.Sprintf("%s is a loopback address", ip)
+	}
+	return fmt.Sprintf("%s is not an address of this controller", ip)
+}
+
+// checkInformURL records whether the inform URL reported by an AP points at an address it can reach.
+func (m *Manager) checkInformURL(mac [6]byte, remoteAddr, informURL string) {
+	if informURL == "" {
+		return
+	}
+	host := Host(remoteAddr)
+	apIP := net.ParseIP(host)
+	expected := m.controllerAddrFor(apIP, "")
+
+	var subnets []*net.IPNet
+	if addrs, err := net.InterfaceAddrs(); err == nil {
+		for _, a := range addrs {
+			if subnet, ok := a.(*net.IPNet); ok {
+				subnets = append(subnets, subnet)
+			}
+		}
+	}
+	reason := informURLProblem(informURL, expected, apIP, subnets)
+
+	m.informURLLock.Lock()
+	defer m.informURLLock.Unlock()
+	if reason == "" {
+		delete(m.informURLProblems, mac)
+		return
+	}
+	if p, ok := m.informURLProblems[mac]; !ok || p.InformURL != informURL {
+		fmt.Printf("[INFORM] [%x] Inform URL %s is probably unreachable: %s\n", mac, informURL, reason)
+	}
+	m.informURLProblems[mac] = &InformURLProblem{
+		MAC:       FormatMAC(mac),
+		IP:        host,
+		InformURL: informURL,
+		Expected:  expected,
+		Reason:    reason,
+		LastSeen:  time.Now(),
+	}
+}
+
+// InformURLProblems returns the APs whose inform URL points at an address they probably cannot reach.
+func (m *Manager) InformURLProblems() []InformURLProblem {
+	m.informURLLock.Lock()
+	defer m.informURLLock.Unlock()
+	out := make([]InformURLProblem, 0, len(m.informURLProblems))
+	for _, p := range m.informURLProblems {
+		out = append(out, *p)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].MAC < out[j].MAC })
+	return out
+}
@@ -0,0 +1,94 @@
+package manager
+
+import (
+	"net"
+	"testing"
+)
+
+func TestControllerAddrFor(t *testing.T) {
+	m := newTestManager(t)
+	m.localAddr = "192.168.1.2"
+	if addr := m.controllerAddrFor(net.IPv4(10, 0, 0, 5), ""); addr != "192.168.1.2" {
+		t.Errorf("Expected override to be used, got %q", addr)
+	}
+
+	m.localAddr = ""
+	if addr := m.controllerAddrFor(net.IPv4(127, 0, 0, 2), ""); addr != "127.0.0.1" {
+		t.Errorf("Expected route to loopback to use 127.0.0.1, got %q", addr)
+	}
+	if addr := m.controllerAddrForRemote("127.0.0.2:41234"); addr != "127.0.0.1" {
+		t.Errorf("Expected route to loopback to use 127.0.0.1, got %q", addr)
+	}
+}
+
+func TestInformURLProblem(t *testing.T) {
+	var subnets []*net.IPNet
+	for _, c := range []string{"192.168.1.2/24", "172.17.0.1/16"} {
+		ip, subnet, err := net.ParseCIDR(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		subnet.IP = ip
+		subnets = append(subnets, subnet)
+	}
+	ap := net.IPv4(192, 168, 1, 20)
+
+	for _, tc := range []struct {
+		url     string
+		problem bool
+	}{
+		{"http://192.168.1.2:8080/inform", false},
+		{"http://unifi:8080/inform", false},
+		{"http://172.17.0.1:8080/inform", true}, // Docker bridge.
+		{"http://10.9.9.9:8080/inform", true},
+		{"http://127.0.0.1:8080/inform", true},
+		{"not a url", true},
+	} {
+		reason := informURLProblem(tc.url, "192.168.1.2", ap, subnets)
+		if (reason != "") != tc.problem {
+			t.Errorf("informURLProblem(%s) = %q, expected problem=%v", tc.url, reason, tc.problem)
+		}
+	}
+
+	// An address on the AP's subnet is fine, even if it is not the preferred one.
+	if reason := informURLProblem("http://192.168.1.2:8080/inform", "10.0.0.1", ap, subnets); reason != "" {
+		t.Errorf("Unexpected problem %q", reason)
+	}
+}
+
+func TestCheckInformURL(t *testing.T) {
+	m := newTestManager(t)
+	m.localAddr = "192.168.1.2"
+	mac := [6]byte{1, 2, 3, 4, 5, 6}
+	m.checkInformURL(mac, "192.168.1.20:41234", "http://10.9.9.9:8421/inform")
+	if p := m.InformURLProblems(); len(p) != 1 || p[0].MAC != "01:02:03:04:05:06" || p[0].Expected != "192.168.1.2" {
+		t.Errorf("Unexpected problems %+v", p)
+	}
+	m.checkInformURL(mac, "192.168.1.20:41234", "http://192.168.1.2:8421/inform")
+	if p := m.InformURLProblems(); len(p) != 0 {
+		t.Errorf("Expected problem to be cleared, got %+v", p)
+	}
+}
+
+func TestHost(t *testing.T) {
+	for in, expected := range map[string]string{
+		"192.168.1.20:41234":   "192.168.1.20",
+		"192.168.1.20":         "192.168.1.20",
+		"[fd00::5]:41234":      "fd00::5",
+		"[fe80::1%eth0]:10001": "fe80::1%eth0",
+		"fd00::5":              "fd00::5",
+		"[fd00::5]":            "fd00::5",
+		(&net.UDPAddr{IP: net.ParseIP("fd00::5"), Port: 10001}).String(): "fd00::5",
+	} {
+		if out := Host(in); out != expected {
+			t.Errorf("Host(%q) = %q, expected %q", in, out, expected)
+		}
+	}
+}
+
+func TestBasicClientIPv6(t *testing.T) {
+	c := &BasicClient{IP: &net.UDPAddr{IP: net.ParseIP("fd00::5"), Port: 10001}}
+	if ip := c.GetIP(); ip != "fd00::5" {
+		t.Errorf("GetIP() = %q, expected fd00::5", ip)
+	}
+}
@@ -0,0 +1,308 @@
+package manager
+
+import (
+	"fmt"
+	"gofi/adopt"
+	"gofi/config"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Reasons an adoption attempt failed.
+const (
+	FailureAuth        = "auth-failed"
+	FailureUnreachable = "unreachable"
+	FailureHostKey     = "host-key-mismatch"
+	FailureTimeout     = "inform-timeout"
+	FailureDecrypt     = "inform-undecryptable"
+	FailureNoHostKey   = "no-host-key" // Informs cannot be decrypted, and re-adoption is unsafe.
+	FailureOther       = "error"
+)
+
+const (
+	adoptRetryBase   = 5 * time.Second
+	adoptRetryMax    = 5 * time.Minute
+	adoptMaxAttempts = 10
+	// adoptInformTimeout is how long to wait for the first inform after set-adopt succeeds.
+	adoptInformTimeout = 90 * time.Second
+	adoptCheckInterval = 5 * time.Second
+)
+
+// AdoptionStatus describes the progress of adopting an AP.
+type AdoptionStatus struct {
+	MAC         string
+	Attempts    int
+	LastAttempt time.Time
+	NextAttempt time.Time // Zero if no further attempts are scheduled.
+
+	// AwaitingInform is set if set-adopt succeeded, and we are waiting for the AP to inform.
+	AwaitingInform bool
+	FailureReason  string
+	LastError      string
+}
+
+// adoption tracks an adoption in progress.
+type adoption struct {
+	AdoptionStatus
+	accessPoint AP
+	cfg         *adopt.Config
+	attempting  bool // Set while an attempt is running.
+}
+
+// adoptionResult is the outcome of an adoption attempt, which is reported to the Run loop.
+type adoptionResult struct {
+	a   *adoption
+	cfg adopt.Config // The configuration used, with the host key presented by the device.
+	err error
+}
+
+// adoptions tracks all adoptions which have not yet resulted in an inform.
+type adoptions struct {
+	lock  sync.Mutex
+	byMAC map[[6]byte]*adoption
+}
+
+// retryDelay returns how long to wait before retrying after the given number of failed attempts.
+func retryDelay(attempts int) time.Duration {
+	if attempts < 1 {
+		return 0
+	}
+	if attempts > 16 {
+		return adoptRetryMax
+	}
+	d := adoptRetryBase << uint(attempts-1)
+	if d > adoptRetryMax {
+		return adoptRetryMax
+	}
+	return d
+}
+
+// failureReason categorizes the error returned from an adoption attempt.
+func failureReason(err error) string {
+	msg := err.Error()
+	switch {
+	case strings.Contains(msg, adopt.ErrHostKeyMismatch.Error()):
+		return FailureHostKey
+	case strings.Contains(msg, "unable to authenticate"):
+		return FailureAuth
+	}
+	if _, isNetErr := err.(net.Error); isNetErr {
+		return FailureUnreachable
+	}
+	return FailureOther
+}
+
+// startAdoption begins adopting an AP, replacing any adoption in progress.
+func (m *Manager) startAdoption(accessPoint AP, cfg *adopt.Config) {
+	a := &adoption{
+		AdoptionStatus: AdoptionStatus{MAC: FormatMAC(accessPoint.MAC())},
+		accessPoint:    accessPoint,
+		cfg:            cfg,
+	}
+	m.adoptions.lock.Lock()
+	m.adoptions.byMAC[accessPoint.MAC()] = a
+	m.adoptions.lock.Unlock()
+
+	setAPConfigDirty(accessPoint)
+	m.attemptAdoption(a)
+}
+
+// attemptAdoption starts a single adoption attempt over SSH. The attempt runs in the background,
+// and its result is handled by adoptionAttempted.
+func (m *Manager) attemptAdoption(a *adoption) {
+	mac := a.accessPoint.MAC()
+	a.accessPoint.SetState(StateAdopting)
+
+	m.adoptions.lock.Lock()
+	a.Attempts++
+	a.LastAttempt = time.Now()
+	a.NextAttempt = time.Time{}
+	a.AwaitingInform = false
+	a.attempting = true
+	cfg := *a.cfg
+	m.adoptions.lock.Unlock()
+
+	if pinner, canPin := a.accessPoint.(HostKeyPinner); canPin && pinner.HostKey() != "" {
+		cfg.HostKey = pinner.HostKey()
+	}
+	go func() {
+		err := adopt.Adopt(&cfg)
+		if err != nil && failureReason(err) == FailureAuth && (cfg.User != "ubnt" || cfg.Pass != "ubnt") {
+			// The device may have been reset to its default credentials.
+			fmt.Printf("[ADOPT] [%x] Authentication failed, retrying with default credentials\n", mac)
+			cfg.User, cfg.Pass = "ubnt", "ubnt"
+			err = adopt.Adopt(&cfg)
+		}
+		m.adoptResults <- &adoptionResult{a: a, cfg: cfg, err: err}
+	}()
+}
+
+// adoptionAttempted handles the result of an adoption attempt, scheduling a retry if it failed.
+// Results for adoptions which have since completed or been replaced only update the AP's credentials.
+func (m *Manager) adoptionAttempted(r *adoptionResult) {
+	a, mac := r.a, r.a.accessPoint.MAC()
+	m.adoptions.lock.Lock()
+	a.attempting = false
+	current := m.adoptions.byMAC[mac] == a
+	m.adoptions.lock.Unlock()
+
+	if r.err != nil {
+		if current {
+			m.adoptionFailed(a, failureReason(r.err), r.err.Error())
+		}
+		return
+	}
+
+	if pinner, canPin := a.accessPoint.(HostKeyPinner); canPin && pinner.HostKey() != r.cfg.HostKey {
+		fmt.Printf("[ADOPT] [%x] Pinned host key %s\n", mac, r.cfg.HostKey)
+		if err := pinner.SetHostKey(r.cfg.HostKey); err != nil {
+			fmt.Printf("[ADOPT] [%x] Failed to pin host key: %s\n", mac, err)
+		}
+	}
+
+	if current {
+		m.adoptions.lock.Lock()
+		a.AwaitingInform = true
+		a.FailureReason = ""
+		a.LastError = ""
+		m.adoptions.lock.Unlock()
+		a.accessPoint.SetState(StateAdopted)
+	}
+	fmt.Printf("[ADOPT] [%x] Adoption for %s successful, waiting for inform.\n", mac, r.cfg.APAddr)
+	if err := rotateSSHPw(a.accessPoint); err != nil {
+		fmt.Printf("[ADOPT] [%x] Failed to set device credentials: %s\n", mac, err)
+	}
+}
+
+// adoptionFailed records the failure of an adoption attempt, scheduling a retry with backoff.
+func (m *Manager) adoptionFailed(a *adoption, reason, errMsg string) {
+	m.adoptions.lock.Lock()
+	a.AwaitingInform = false
+	a.FailureReason = reason
+	a.LastError = errMsg
+	if a.Attempts < adoptMaxAttempts {
+		a.NextAttempt = time.Now().Add(retryDelay(a.Attempts))
+	} else {
+		a.NextAttempt = time.Time{}
+	}
+	next := a.NextAttempt
+	m.adoptions.lock.Unlock()
+
+	a.accessPoint.SetState(StateAdoptFailed)
+	if next.IsZero() {
+		fmt.Printf("[ADOPT] [%x] Adopt failed (%s): %s. Giving up after %d attempts.\n", a.accessPoint.MAC(), reason, errMsg, a.Attempts)
+	} else {
+		fmt.Printf("[ADOPT] [%x] Adopt failed (%s): %s. Retrying in %s.\n", a.accessPoint.MAC(), reason, errMsg, next.Sub(time.Now()).Round(time.Second))
+	}
+}
+
+// adoptionRediscovered is called when a device with an adoption in progress sends a discovery packet.
+// The address is updated, and attempts are restarted if we had given up.
+func (m *Manager) adoptionRediscovered(mac [6]byte, apAddr string) {
+	m.adoptions.lock.Lock()
+	defer m.adoptions.lock.Unlock()
+	a, ok := m.adoptions.byMAC[mac]
+	if !ok {
+		return
+	}
+	if a.cfg == nil {
+		return // Re-adoption was refused, see scheduleReadoption.
+	}
+	a.cfg.APAddr = apAddr
+	if !a.attempting && !a.AwaitingInform && a.NextAttempt.IsZero() {
+		a.Attempts = 0
+		a.NextAttempt = time.Now()
+	}
+}
+
+// scheduleReadoption starts adopting an AP again, using its existing key and credentials.
+// This is used when an AP's informs can no longer be decrypted with the stored key. Anything can
+// send an inform with the AP's MAC address, so the AP is contacted at its stored address, and only
+// if its host key is pinned. Otherwise the failure is recorded for the operator to resolve.
+func (m *Manager) scheduleReadoption(accessPoint AP) {
+	m.adoptions.lock.Lock()
+	defer m.adoptions.lock.Unlock()
+	if _, inProgress := m.adoptions.byMAC[accessPoint.MAC()]; inProgress {
+		return
+	}
+
+	a := &adoption{
+		AdoptionStatus: AdoptionStatus{
+			MAC:           FormatMAC(accessPoint.MAC()),
+			FailureReason: FailureDecrypt,
+		},
+		accessPoint: accessPoint,
+	}
+	m.adoptions.byMAC[accessPoint.MAC()] = a
+	ip := accessPoint.GetIP()
+	if pinner, canPin := accessPoint.(HostKeyPinner); !canPin || pinner.HostKey() == "" || ip == "" {
+		fmt.Printf("[ADOPT] [%x] Informs cannot be decrypted, and no host key is pinned to re-adopt safely\n", accessPoint.MAC())
+		a.FailureReason = FailureNoHostKey
+		a.LastError = "informs cannot be decrypted, and no host key is pinned: forget the AP and adopt it again"
+		return
+	}
+
+	a.cfg = adopt.NewConfig(net.JoinHostPort(ip, "22"), config.ControllerAddr(m.controllerAddrFor(net.ParseIP(ip), ""), m.httpListenerAddr), accessPoint.SSHPw())
+	a.cfg.Key = accessPoint.AuthKey()
+	a.cfg.User = sshUser(accessPoint)
+	a.NextAttempt = time.Now()
+	fmt.Printf("[ADOPT] [%x] Informs cannot be decrypted, scheduling re-adoption\n", accessPoint.MAC())
+}
+
+// cancelAdoption stops tracking the adoption of an AP, returning it if one was in progress.
+func (m *Manager) cancelAdoption(mac [6]byte) *adoption {
+	m.adoptions.lock.Lock()
+	defer m.adoptions.lock.Unlock()
+	a := m.adoptions.byMAC[mac]
+	delete(m.adoptions.byMAC, mac)
+	return a
+}
+
+// adoptionComplete is called when an inform is successfully decoded from an AP.
+func (m *Manager) adoptionComplete(mac [6]byte) {
+	if a := m.cancelAdoption(mac); a != nil {
+		fmt.Printf("[ADOPT] [%x] Adoption complete after %d attempt(s)\n", mac, a.Attempts)
+	}
+}
+
+// checkAdoptions times out adoptions which have not resulted in an inform, and retries
+// any failed adoptions which are due.
+func (m *Manager) checkAdoptions() {
+	var timedOut, due []*adoption
+	now := time.Now()
+
+	m.adoptions.lock.Lock()
+	for _, a := range m.adoptions.byMAC {
+		if a.attempting {
+			continue
+		}
+		if a.AwaitingInform && now.Sub(a.LastAttempt) > adoptInformTimeout {
+			timedOut = append(timedOut, a)
+		} else if !a.NextAttempt.IsZero() && !now.Before(a.NextAttempt) {
+			due = append(due, a)
+		}
+	}
+	m.adoptions.lock.Unlock()
+
+	for _, a := range timedOut {
+		m.adoptionFailed(a, FailureTimeout, "no inform received after set-adopt")
+	}
+	for _, a := range due {
+		m.attemptAdoption(a)
+	}
+}
+
+// AdoptionStatuses returns the status of all adoptions in progress, ordered by MAC address.
+func (m *Manager) AdoptionStatuses() []AdoptionStatus {
+	m.adoptions.lock.Lock()
+	defer m.adoptions.lock.Unlock()
+	out := make([]AdoptionStatus, 0, len(m.adoptions.byMAC))
+	for _, a := range m.adoptions.byMAC {
+		out = append(out, a.AdoptionStatus)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].MAC < out[j].MAC })
+	return out
+}
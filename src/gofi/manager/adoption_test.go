@@ -0,0 +1,189 @@
+package manager
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"gofi/adopt"
+	"gofi/packet"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestRetryDelay(t *testing.T) {
+	for attempts, expected := range map[int]time.Duration{
+		0:  0,
+		1:  5 * time.Second,
+		2:  10 * time.Second,
+		4:  40 * time.Second,
+		7:  adoptRetryMax,
+		50: adoptRetryMax,
+	} {
+		if d := retryDelay(attempts); d != expected {
+			t.Errorf("retryDelay(%d) = %s, expected %s", attempts, d, expected)
+		}
+	}
+}
+
+func TestFailureReason(t *testing.T) {
+	tcs := []struct {
+		err      error
+		expected string
+	}{
+		{fmt.Errorf("ssh: handshake failed: %v", adopt.ErrHostKeyMismatch), FailureHostKey},
+		{errors.New("ssh: handshake failed: ssh: unable to authenticate, attempted methods [none password], no supported methods remain"), FailureAuth},
+		{&net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}, FailureUnreachable},
+		{errors.New("Process exited with status 1"), FailureOther},
+	}
+	for _, tc := range tcs {
+		if r := failureReason(tc.err); r != tc.expected {
+			t.Errorf("failureReason(%q) = %q, expected %q", tc.err, r, tc.expected)
+		}
+	}
+}
+
+func TestAdoptionTimeout(t *testing.T) {
+	m := newTestManager(t)
+	mac := testMAC
+	ap := &BasicClient{MACAddr: mac}
+	m.adoptions.byMAC[mac] = &adoption{
+		AdoptionStatus: AdoptionStatus{
+			MAC:            FormatMAC(mac),
+			Attempts:       1,
+			LastAttempt:    time.Now().Add(-2 * adoptInformTimeout),
+			AwaitingInform: true,
+		},
+		accessPoint: ap,
+		cfg:         &adopt.Config{APAddr: "192.168.1.20:22"},
+	}
+
+	m.checkAdoptions()
+	s := m.AdoptionStatuses()
+	if len(s) != 1 {
+		t.Fatalf("Expected 1 adoption, got %d", len(s))
+	}
+	if s[0].FailureReason != FailureTimeout || s[0].AwaitingInform {
+		t.Errorf("Expected adoption to time out, got %+v", s[0])
+	}
+	if s[0].NextAttempt.IsZero() || s[0].NextAttempt.Before(time.Now()) {
+		t.Errorf("Expected retry to be scheduled in the future, got %s", s[0].NextAttempt)
+	}
+	if ap.GetState() != StateAdoptFailed {
+		t.Errorf("Expected state %d, got %d", StateAdoptFailed, ap.GetState())
+	}
+
+	// Give up, then check a new discovery restarts attempts at the new address.
+	a := m.adoptions.byMAC[mac]
+	a.Attempts = adoptMaxAttempts
+	m.adoptionFailed(a, FailureUnreachable, "no route to host")
+	if s := m.AdoptionStatuses(); !s[0].NextAttempt.IsZero() {
+		t.Errorf("Expected no retry after %d attempts", adoptMaxAttempts)
+	}
+	m.adoptionRediscovered(mac, "192.168.1.30:22")
+	if a.Attempts != 0 || a.NextAttempt.IsZero() || a.cfg.APAddr != "192.168.1.30:22" {
+		t.Errorf("Expected rediscovery to restart adoption, got %+v %+v", a.AdoptionStatus, a.cfg)
+	}
+
+	m.adoptionComplete(mac)
+	if len(m.AdoptionStatuses()) != 0 {
+		t.Error("Expected adoption to be removed once complete")
+	}
+}
+
+func TestRemoteAdoptionHints(t *testing.T) {
+	m := newTestManager(t)
+	m.localAddr = "192.168.1.2"
+	h, err := m.RemoteAdoptionHints()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.InformURL != "http://192.168.1.2:8421/inform" || h.DHCPOption43 != "0104c0a80102" || h.DNSRecord != "unifi. IN A 192.168.1.2" {
+		t.Errorf("Unexpected hints: %+v", h)
+	}
+	if h.Warning == "" {
+		t.Error("Expected warning when not listening on port 8080")
+	}
+
+	m.httpListenerAddr = ":8080"
+	if h, _ = m.RemoteAdoptionHints(); h.Warning != "" {
+		t.Errorf("Unexpected warning: %s", h.Warning)
+	}
+
+	m.localAddr = "fd00::2"
+	if h, err = m.RemoteAdoptionHints(); err != nil {
+		t.Fatal(err)
+	}
+	if h.InformURL != "http://[fd00::2]:8080/inform" || h.DHCPOption43 != "" || h.DNSRecord != "unifi. IN AAAA fd00::2" {
+		t.Errorf("Unexpected IPv6 hints: %+v", h)
+	}
+}
+
+func TestAdoptionAttemptReportsResult(t *testing.T) {
+	m := newTestManager(t)
+	ap := &BasicClient{MACAddr: testMAC}
+	m.startAdoption(ap, &adopt.Config{APAddr: "127.0.0.1:1", User: "ubnt", Pass: "ubnt"})
+	if s := m.AdoptionStatuses(); len(s) != 1 || s[0].Attempts != 1 || !s[0].NextAttempt.IsZero() {
+		t.Fatalf("Expected attempt in progress, got %+v", s)
+	}
+	m.adoptionRediscovered(testMAC, "127.0.0.1:2")
+	if a := m.adoptions.byMAC[testMAC]; a.Attempts != 1 || !a.NextAttempt.IsZero() {
+		t.Error("Rediscovery restarted an adoption with an attempt in progress")
+	}
+
+	m.adoptionAttempted(<-m.adoptResults)
+	s := m.AdoptionStatuses()
+	if s[0].FailureReason != FailureUnreachable || s[0].NextAttempt.IsZero() || ap.GetState() != StateAdoptFailed {
+		t.Errorf("Expected failed attempt to be retried, got %+v", s[0])
+	}
+
+	// Results for adoptions which are no longer tracked are ignored.
+	a := m.adoptions.byMAC[testMAC]
+	m.adoptionComplete(testMAC)
+	m.adoptionAttempted(&adoptionResult{a: a, err: errors.New("Process exited with status 1")})
+	if len(m.AdoptionStatuses()) != 0 {
+		t.Error("Expected stale result to be ignored")
+	}
+}
+
+type pinnedClient struct {
+	BasicClient
+	hostKey string
+}
+
+func (c *pinnedClient) HostKey() string { return c.hostKey }
+
+func (c *pinnedClient) SetHostKey(key string) error {
+	c.hostKey = key
+	return nil
+}
+
+func TestReadoptionUsesStoredAddress(t *testing.T) {
+	pinned := &pinnedClient{BasicClient: BasicClient{MACAddr: testMAC, EncryptionKey: testKey, IP: &net.IPAddr{IP: net.IP{192, 168, 1, 20}}}, hostKey: "ssh-ed25519 AAAA"}
+	unpinned := &pinnedClient{BasicClient: BasicClient{MACAddr: [6]byte{0xf0, 0x9f, 0xc2, 0, 0, 1}, EncryptionKey: testKey, IP: &net.IPAddr{IP: net.IP{192, 168, 1, 21}}}}
+	m := newTestManager(t, pinned, unpinned)
+
+	// Anything can send an inform with the MAC address of a known AP.
+	for _, ap := range []*pinnedClient{pinned, unpinned} {
+		if _, err := m.HandleInform("10.6.6.6:41234", encodeInform(t, ap.MAC(), bytes.Repeat([]byte{0x66}, 16), &packet.InformData{})); err == nil {
+			t.Error("Expected error for undecryptable inform")
+		}
+	}
+	for _, a := range m.adoptions.byMAC {
+		if a.cfg != nil && a.cfg.APAddr != net.JoinHostPort(a.accessPoint.GetIP(), "22") {
+			t.Errorf("Expected re-adoption of %s to use its stored address, got %s", a.MAC, a.cfg.APAddr)
+		}
+	}
+
+	if a := m.adoptions.byMAC[pinned.MAC()]; a == nil || a.cfg == nil || a.NextAttempt.IsZero() {
+		t.Errorf("Expected re-adoption of AP with pinned host key to be scheduled, got %+v", a)
+	}
+	a := m.adoptions.byMAC[unpinned.MAC()]
+	if a == nil || a.FailureReason != FailureNoHostKey || !a.NextAttempt.IsZero() {
+		t.Fatalf("Expected re-adoption of AP without pinned host key to be refused, got %+v", a)
+	}
+	m.adoptionRediscovered(unpinned.MAC(), "10.6.6.6:22")
+	if !a.NextAttempt.IsZero() {
+		t.Error("Rediscovery restarted a refused re-adoption")
+	}
+}
@@ -0,0 +1,164 @@
+package manager
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"gofi/adopt"
+	"gofi/packet"
+	"net"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// PendingDevice describes a device which has been discovered, but has not been approved for adoption.
+type PendingDevice struct {
+	MAC             string
+	IP              string
+	Interface       string // Local interface the device was discovered on, if known.
+	Model           string
+	Hostname        string
+	FirmwareVersion string
+	LastSeen        time.Time
+
+	// ViaInform is set if the device was seen informing in its factory-default state, rather than
+	// via discovery. Such devices are adopted on their next inform once approved.
+	ViaInform bool
+
+	discovery   *packet.Discovery
+	credentials *adopt.Config // Set for devices adopted by address, see AdoptAt.
+}
+
+// ParseMAC parses a MAC address in either aa:bb:cc:dd:ee:ff or aabbccddeeff form.
+func ParseMAC(s string) ([6]byte, error) {
+	var out [6]byte
+	s = strings.ToLower(strings.TrimSpace(s))
+	if !strings.ContainsAny(s, ":-") {
+		b, err := hex.DecodeString(s)
+		if err != nil || len(b) != 6 {
+			return out, errors.New("invalid MAC address " + s)
+		}
+		copy(out[:], b)
+		return out, nil
+	}
+
+	hw, err := net.ParseMAC(s)
+	if err != nil {
+		return out, err
+	}
+	if len(hw) != 6 {
+		return out, errors.New("invalid MAC address " + s)
+	}
+	copy(out[:], hw)
+	return out, nil
+}
+
+// FormatMAC returns the MAC address in aa:bb:cc:dd:ee:ff form.
+func FormatMAC(mac [6]byte) string {
+	return net.HardwareAddr(mac[:]).String()
+}
+
+// SetAutoApprove sets the patterns of MAC addresses (in aa:bb:cc:dd:ee:ff form) which are adopted
+// without explicit approval. Patterns use the syntax of path.Match, ie: f0:9f:c2:* or *.
+func (m *Manager) SetAutoApprove(patterns []string) error {
+	for _, p := range patterns {
+		if _, err := path.Match(p, ""); err != nil {
+			return fmt.Errorf("invalid pattern %q: %v", p, err)
+		}
+	}
+	m.pendingLock.Lock()
+	defer m.pendingLock.Unlock()
+	m.autoApprove = patterns
+	return nil
+}
+
+// isApproved returns true if the device may be adopted.
+func (m *Manager) isApproved(mac [6]byte) bool {
+	m.pendingLock.Lock()
+	defer m.pendingLock.Unlock()
+	if m.approved[mac] {
+		return true
+	}
+	macStr := FormatMAC(mac)
+	for _, p := range m.autoApprove {
+		if match, _ := path.Match(strings.ToLower(p), macStr); match {
+			return true
+		}
+	}
+	return false
+}
+
+// revokeApproval requires a device to be approved again before it is adopted, unless it is already
+// awaiting approval. Devices matching an auto-approve pattern remain approved. Returns true if the
+// device was not already awaiting approval.
+func (m *Manager) revokeApproval(mac [6]byte) bool {
+	m.pendingLock.Lock()
+	defer m.pendingLock.Unlock()
+	if _, pending := m.pending[mac]; pending {
+		return false
+	}
+	delete(m.approved, mac)
+	return true
+}
+
+// addPending records that a device is awaiting approval.
+func (m *Manager) addPending(discoveryPkt *packet.Discovery, viaInform bool) {
+	m.pendingLock.Lock()
+	defer m.pendingLock.Unlock()
+	p, exists := m.pending[discoveryPkt.MAC]
+	if !exists {
+		fmt.Printf("[DISCOVERY] [%x] Device %s (%s) on %s is awaiting approval for adoption\n", discoveryPkt.MAC, discoveryPkt.Hostname, discoveryPkt.Platform, discoveryPkt.IPInfo)
+		p = &PendingDevice{MAC: FormatMAC(discoveryPkt.MAC)}
+		m.pending[discoveryPkt.MAC] = p
+	}
+	p.IP = Host(discoveryPkt.IPInfo.String())
+	p.Interface = discoveryPkt.Interface
+	p.Model = discoveryPkt.Platform
+	p.Hostname = discoveryPkt.Hostname
+	p.FirmwareVersion = discoveryPkt.FirmwareVersion
+	p.LastSeen = time.Now()
+	p.ViaInform = viaInform
+	p.discovery = discoveryPkt
+}
+
+// removePending removes a device from the pending list, returning it if it was pending.
+func (m *Manager) removePending(mac [6]byte) *PendingDevice {
+	m.pendingLock.Lock()
+	defer m.pendingLock.Unlock()
+	p := m.pending[mac]
+	delete(m.pending, mac)
+	return p
+}
+
+// PendingDevices returns the devices awaiting approval for adoption, ordered by MAC address.
+func (m *Manager) PendingDevices() []PendingDevice {
+	m.pendingLock.Lock()
+	defer m.pendingLock.Unlock()
+	out := make([]PendingDevice, 0, len(m.pending))
+	for _, p := range m.pending {
+		out = append(out, *p)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].MAC < out[j].MAC })
+	return out
+}
+
+// Approve approves a pending device for adoption. Devices found by discovery are adopted
+// immediately, devices which are informing are adopted on their next inform.
+func (m *Manager) Approve(mac [6]byte) error {
+	m.pendingLock.Lock()
+	p, ok := m.pending[mac]
+	if ok {
+		m.approved[mac] = true
+	}
+	m.pendingLock.Unlock()
+
+	if !ok {
+		return errors.New("no such pending device")
+	}
+	if !p.ViaInform {
+		m.approvals <- mac
+	}
+	return nil
+}
@@ -0,0 +1,63 @@
+package manager
+
+import (
+	"gofi/packet"
+	"net"
+	"testing"
+)
+
+func TestParseMAC(t *testing.T) {
+	expected := [6]byte{0xf0, 0x9f, 0xc2, 0xaa, 0xbb, 0xcc}
+	for _, in := range []string{"f0:9f:c2:aa:bb:cc", "F0-9F-C2-AA-BB-CC", "f09fc2aabbcc"} {
+		mac, err := ParseMAC(in)
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", in, err)
+		}
+		if mac != expected {
+			t.Errorf("%q: expected %x, got %x", in, expected, mac)
+		}
+	}
+	for _, in := range []string{"", "f09fc2aabb", "f0:9f:c2:aa:bb:cc:dd:ee", "zz:9f:c2:aa:bb:cc"} {
+		if _, err := ParseMAC(in); err == nil {
+			t.Errorf("%q: expected error", in)
+		}
+	}
+}
+
+func TestApproval(t *testing.T) {
+	m := newTestManager(t)
+	ours := testMAC
+	neighbours := [6]byte{0x80, 0x2a, 0xa8, 0x11, 0x22, 0x33}
+
+	if err := m.SetAutoApprove([]string{"[a-"}); err == nil {
+		t.Error("Expected error for invalid pattern")
+	}
+	if err := m.SetAutoApprove([]string{"F0:9F:C2:*"}); err != nil {
+		t.Fatal(err)
+	}
+	if !m.isApproved(ours) {
+		t.Error("Expected device to be auto-approved")
+	}
+	if m.isApproved(neighbours) {
+		t.Error("Expected device to not be auto-approved")
+	}
+
+	if err := m.Approve(neighbours); err == nil {
+		t.Error("Expected error approving device which is not pending")
+	}
+	m.addPending(&packet.Discovery{MAC: neighbours, IPInfo: &net.UDPAddr{IP: net.IPv4(192, 168, 1, 20), Port: 10001}, Platform: "U7LR"}, false)
+	pending := m.PendingDevices()
+	if len(pending) != 1 || pending[0].MAC != "80:2a:a8:11:22:33" || pending[0].IP != "192.168.1.20" || pending[0].Model != "U7LR" {
+		t.Errorf("Unexpected pending devices: %+v", pending)
+	}
+
+	if err := m.Approve(neighbours); err != nil {
+		t.Fatal(err)
+	}
+	if !m.isApproved(neighbours) {
+		t.Error("Expected device to be approved")
+	}
+	if mac := <-m.approvals; mac != neighbours {
+		t.Errorf("Expected approval to be queued for adoption, got %x", mac)
+	}
+}
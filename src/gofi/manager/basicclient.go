@@ -3,7 +3,6 @@ package manager
 import (
 	"gofi/config"
 	"net"
-	"strings"
 )
 
 // BasicClient is an in-memory representation of AP state.
@@ -58,5 +57,8 @@ func (c *BasicClient) SSHPw() string {
 
 // GetIP returns the IP as a string.
 func (c *BasicClient) GetIP() string {
-	return strings.Split(c.IP.String(), ":")[0]
+	if c.IP == nil {
+		return ""
+	}
+	return Host(c.IP.String())
 }
@@ -0,0 +1,116 @@
+package manager
+
+import (
+	"errors"
+	"fmt"
+	"gofi/adopt"
+	"net"
+	"time"
+)
+
+// forgetInformTimeout is how long to wait for an AP to inform and receive its set-default command,
+// before resetting it over SSH instead.
+const forgetInformTimeout = 30 * time.Second
+
+// Forget resets an AP to its factory-default configuration and removes it from the controller.
+// The reset is sent the next time the AP informs, or over SSH if the AP does not inform in time.
+func (m *Manager) Forget(mac [6]byte) error {
+	m.apLock.Lock()
+	if m.MacAddrToKey[mac] == nil {
+		m.apLock.Unlock()
+		return errors.New("no such AP")
+	}
+	m.queuedActions[mac] = &APAction{
+		Action: "set-default",
+	}
+	m.apLock.Unlock()
+	m.cancelAdoption(mac)
+
+	m.forgetLock.Lock()
+	defer m.forgetLock.Unlock()
+	if _, inProgress := m.forgetting[mac]; !inProgress {
+		m.forgetting[mac] = time.Now()
+	}
+	fmt.Printf("[MANAGER] [%x] Queued reset to factory defaults\n", mac)
+	return nil
+}
+
+// resetResult is the outcome of resetting an AP over SSH, which is reported to the Run loop.
+type resetResult struct {
+	accessPoint AP
+	err         error
+}
+
+// checkForgets resets APs over SSH, if they have not received their set-default command via inform.
+// Resets run in the background, and their results are handled by resetAttempted.
+func (m *Manager) checkForgets() {
+	var due [][6]byte
+	m.forgetLock.Lock()
+	for mac, since := range m.forgetting {
+		if !m.resetting[mac] && time.Since(since) > forgetInformTimeout {
+			m.resetting[mac] = true
+			due = append(due, mac)
+		}
+	}
+	m.forgetLock.Unlock()
+
+	for _, mac := range due {
+		accessPoint := m.lookupAP(mac)
+		if accessPoint == nil {
+			m.forgetLock.Lock()
+			delete(m.forgetting, mac)
+			delete(m.resetting, mac)
+			m.forgetLock.Unlock()
+			continue
+		}
+
+		fmt.Printf("[MANAGER] [%x] AP did not inform, resetting over SSH\n", mac)
+		cfg := &adopt.Config{APAddr: net.JoinHostPort(accessPoint.GetIP(), "22"), User: sshUser(accessPoint), Pass: accessPoint.SSHPw()}
+		if pinner, canPin := accessPoint.(HostKeyPinner); canPin {
+			cfg.HostKey = pinner.HostKey()
+		}
+		go func(accessPoint AP, cfg *adopt.Config) {
+			m.resetResults <- &resetResult{accessPoint: accessPoint, err: adopt.RestoreDefault(cfg)}
+		}(accessPoint, cfg)
+	}
+}
+
+// resetAttempted handles the result of resetting an AP over SSH, forgetting the AP even if the
+// reset failed.
+func (m *Manager) resetAttempted(r *resetResult) {
+	mac := r.accessPoint.MAC()
+	if r.err != nil {
+		fmt.Printf("[MANAGER] [%x] Reset failed: %s. The device must be reset manually.\n", mac, r.err)
+	}
+	if m.lookupAP(mac) != r.accessPoint {
+		return // Already forgotten, as the AP informed while being reset.
+	}
+	m.forget(r.accessPoint)
+}
+
+// forget removes all state about an AP from the manager, and from the AP if it implements Forgetter.
+func (m *Manager) forget(accessPoint AP) {
+	mac := accessPoint.MAC()
+	m.apLock.Lock()
+	delete(m.MacAddrToKey, mac)
+	delete(m.queuedActions, mac)
+	delete(m.ledState, mac)
+	m.apLock.Unlock()
+	m.cancelAdoption(mac)
+
+	m.forgetLock.Lock()
+	delete(m.forgetting, mac)
+	delete(m.resetting, mac)
+	m.forgetLock.Unlock()
+	m.pendingLock.Lock()
+	delete(m.approved, mac)
+	m.pendingLock.Unlock()
+
+	if f, ok := accessPoint.(Forgetter); ok {
+		if err := f.Forget(); err != nil {
+			fmt.Printf("[MANAGER] [%x] Failed to forget AP: %s\n", mac, err)
+			return
+		}
+	}
+	fmt.Printf("[MANAGER] [%x] Forgot AP\n", mac)
+}
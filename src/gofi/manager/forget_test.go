@@ -0,0 +1,116 @@
+package manager
+
+import (
+	"bytes"
+	"gofi/config"
+	"gofi/packet"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+type forgettableClient struct {
+	BasicClient
+	forgotten bool
+}
+
+func (c *forgettableClient) Forget() error {
+	c.forgotten = true
+	return nil
+}
+
+func TestForgetViaInform(t *testing.T) {
+	mac := testMAC
+	ap := &forgettableClient{BasicClient: BasicClient{MACAddr: mac, EncryptionKey: testKey}}
+	m := newTestManager(t, ap)
+	m.approved[mac] = true
+
+	if err := m.Forget([6]byte{1, 2, 3, 4, 5, 6}); err == nil {
+		t.Error("Expected error forgetting unknown AP")
+	}
+	if err := m.Forget(mac); err != nil {
+		t.Fatal(err)
+	}
+
+	informPkt := newTestInform(mac)
+	out, err := m.handleNormalInform(nil, informPkt, ap, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reply, err := packet.InformDecode(bytes.NewReader(out))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(reply.IV, informPkt.IV) {
+		t.Error("Reply reused the IV of the inform")
+	}
+	if cmd := decodeReply(t, out, testKey); cmd.Type != "cmd" || cmd.Cmd != "set-default" {
+		t.Errorf("Expected set-default command, got %+v", cmd)
+	}
+
+	if !ap.forgotten {
+		t.Error("Expected AP state to be forgotten")
+	}
+	if _, known := m.MacAddrToKey[mac]; known || m.approved[mac] || len(m.forgetting) != 0 {
+		t.Error("Expected manager to forget AP")
+	}
+}
+
+func TestMigrateViaInform(t *testing.T) {
+	mac := testMAC
+	ap := &forgettableClient{BasicClient: BasicClient{MACAddr: mac, EncryptionKey: testKey, CfgVersion: "abc", Configuration: &config.Config{}}}
+	m := newTestManager(t, ap)
+
+	record, err := m.ExportDevice(mac)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if k, err := record.Key(); err != nil || !bytes.Equal(k, testKey) || record.MAC != "f0:9f:c2:aa:bb:cc" || record.SSHPw != "ubnt" {
+		t.Errorf("Unexpected record %+v (%v)", record, err)
+	}
+
+	if err := m.Migrate(mac, "10.0.0.2:8421"); err == nil {
+		t.Error("Expected error for relative inform URL")
+	}
+	if err := m.Migrate(mac, "http://10.0.0.2:8421/inform"); err != nil {
+		t.Fatal(err)
+	}
+	out, err := m.handleNormalInform(nil, newTestInform(mac), ap, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cmd := decodeReply(t, out, testKey); cmd.Type != "setparam" || !strings.Contains(cmd.ManagementConfig, "mgmt.servers.1.url=http://10.0.0.2:8421/inform\n") || !strings.Contains(cmd.ManagementConfig, "mgmt.authkey=42424242") {
+		t.Errorf("Expected new inform URL with existing key, got %+v", cmd)
+	}
+	if !ap.forgotten || m.MacAddrToKey[mac] != nil {
+		t.Error("Expected AP to be forgotten once moved")
+	}
+}
+
+func TestForgetViaSSH(t *testing.T) {
+	ap := &forgettableClient{BasicClient: BasicClient{MACAddr: testMAC, EncryptionKey: testKey, IP: &net.IPAddr{IP: net.IP{127, 0, 0, 1}}}}
+	m := newTestManager(t, ap)
+	if err := m.Forget(testMAC); err != nil {
+		t.Fatal(err)
+	}
+	m.forgetting[testMAC] = time.Now().Add(-2 * forgetInformTimeout)
+
+	// The reset runs in the background, and is only started once.
+	m.checkForgets()
+	m.checkForgets()
+	r := <-m.resetResults
+	select {
+	case <-m.resetResults:
+		t.Error("Expected a single reset attempt")
+	case <-time.After(100 * time.Millisecond):
+	}
+	if ap.forgotten {
+		t.Fatal("AP was forgotten before the reset finished")
+	}
+
+	m.resetAttempted(r)
+	if !ap.forgotten || m.lookupAP(testMAC) != nil || len(m.forgetting) != 0 || len(m.resetting) != 0 {
+		t.Error("Expected AP to be forgotten after the reset failed")
+	}
+}
@@ -4,6 +4,7 @@ package manager
 
 import (
 	"crypto/rand"
+	"encoding/base64"
 	"encoding/hex"
 	"errors"
 	"fmt"
@@ -11,7 +12,10 @@ import (
 	"gofi/config"
 	"gofi/packet"
 	"gofi/serv"
+	"net"
 	"strings"
+	"sync"
+	"time"
 )
 
 // States which can be passed to SetState()
@@ -21,6 +25,7 @@ const (
 	StateAdopted
 	StateProvisioning
 	StateManaged
+	StateAdoptFailed
 )
 
 // AP represents the controller state of an access point.
@@ -36,12 +41,44 @@ type AP interface {
 	GetConfig() *config.Config
 }
 
+// CredentialedAP is implemented by APs which can store their own SSH credentials.
+// Once such an AP is adopted, the manager generates a unique password for it. The AP should
+// include the hash of the password in its configuration (see config.HashPassword), and use it
+// for any subsequent SSH operations.
+type CredentialedAP interface {
+	AP
+	SetSSHPw(string) error
+}
+
+// HostKeyPinner is implemented by APs which can store the SSH host key of the device. The key
+// presented by the device at adoption is pinned, and subsequent SSH connections must present the same key.
+type HostKeyPinner interface {
+	AP
+	HostKey() string
+	SetHostKey(string) error
+}
+
+// SSHUserAP is implemented by APs which are logged into over SSH as a user other than ubnt, such as
+// APs imported from another controller.
+type SSHUserAP interface {
+	AP
+	SSHUser() string
+}
+
+// Forgetter is implemented by APs which keep state outside of the manager. Forget is called
+// once the AP has been reset, and should delete the AP's key, state and history.
+type Forgetter interface {
+	AP
+	Forget() error
+}
+
 // discoveryStateInitialiser is the spec for the function which is called when the manager recieves a discovery
 // packet.
 //
 // If err is non-nil, all other data will be discarded and processing of the discovery will be aborted.
 // If *adopt.Config is nil, the controller will not be adopted, but will be managed by the Manager using the
 // information provided by the returned AP object.
+// If the discovery has IsDefault set the device has been reset, so should be given a new key even if it is known.
 type discoveryStateInitialiser func(string, string, *packet.Discovery) (AP, *adopt.Config, error)
 
 // unknownAPStateInitialiser is the spec for the function which is called when the manager recieves a Inform
@@ -49,18 +86,52 @@ type discoveryStateInitialiser func(string, string, *packet.Discovery) (AP, *ado
 type unknownAPStateInitialiser func(string, *packet.Inform) (AP, error)
 
 // APAction represents a queued action to perform on an AP, such as locating or rebooting.
+// See QueueAction for the fields each action uses.
 type APAction struct {
 	Action     string
 	StationMac [6]byte
+	InformURL  string
+
+	// Firmware upgrades.
+	URL     string
+	MD5Sum  string
+	Version string
+
+	// Guest authorization. Zero rates or quota mean unlimited.
+	Minutes  int
+	UpKbps   int
+	DownKbps int
+	QuotaMB  int
+
+	Radio string
+	Port  int
 }
 
 // Manager handles controller state.
 type Manager struct {
-	MacAddrToKey map[[6]byte]AP
-
+	apLock        sync.Mutex // Guards MacAddrToKey, queuedActions and ledState.
+	MacAddrToKey  map[[6]byte]AP
 	queuedActions map[[6]byte]*APAction
+	ledState      map[[6]byte]bool // LED state last pushed to each AP
+
+	pendingLock sync.Mutex
+	pending     map[[6]byte]*PendingDevice
+	approved    map[[6]byte]bool
+	autoApprove []string
+	approvals   chan [6]byte
 
-	localAddr        string
+	adoptions    adoptions
+	adoptResults chan *adoptionResult
+
+	forgetLock   sync.Mutex
+	forgetting   map[[6]byte]time.Time // APs queued for a reset, and when the reset was requested.
+	resetting    map[[6]byte]bool      // APs being reset over SSH.
+	resetResults chan *resetResult
+
+	informURLLock     sync.Mutex
+	informURLProblems map[[6]byte]*InformURLProblem
+
+	localAddr        string // If set, the address APs inform to. Otherwise chosen per AP.
 	httpListenerAddr string
 	serv             *serv.Serv
 
@@ -69,45 +140,77 @@ type Manager struct {
 	informChan           chan *packet.InformData
 }
 
-// New creates a new AP manager (controller state).
+// New creates a new AP manager (controller state). If localAddr is empty, the address APs inform to is
+// chosen for each AP from the route to it. Devices are discovered on the given
+// interfaces, or all interfaces if none are given.
 func New(httpListenerAddr, localAddr string, conf *config.Config, stateInitializer discoveryStateInitialiser,
-	apInitializer unknownAPStateInitialiser, informChan chan *packet.InformData) (*Manager, error) {
-	m := &Manager{
-		MacAddrToKey:         map[[6]byte]AP{},
-		queuedActions:        map[[6]byte]*APAction{},
-		localAddr:            localAddr,
-		httpListenerAddr:     httpListenerAddr,
-		discoveryInitializer: stateInitializer,
-		apDiscoverer:         apInitializer,
-		informChan:           informChan,
-	}
-	if stateInitializer == nil {
-		m.discoveryInitializer = func(localAddr, listenerAddr string, discoveryPkt *packet.Discovery) (AP, *adopt.Config, error) {
+	apInitializer unknownAPStateInitialiser, informChan chan *packet.InformData, discoveryIfaces ...string) (*Manager, error) {
+	m := newManager(httpListenerAddr, localAddr, conf)
+	if stateInitializer != nil {
+		m.discoveryInitializer = stateInitializer
+	}
+	if apInitializer != nil {
+		m.apDiscoverer = apInitializer
+	}
+	m.informChan = informChan
+
+	serv, err := serv.New(m, httpListenerAddr, discoveryIfaces)
+	if err != nil {
+		return nil, err
+	}
+
+	m.serv = serv
+
+	return m, nil
+}
+
+// newManager returns a manager with empty state, which is not listening. Discovered APs are
+// managed as BasicClients with the given configuration.
+func newManager(httpListenerAddr, localAddr string, conf *config.Config) *Manager {
+	return &Manager{
+		MacAddrToKey:      map[[6]byte]AP{},
+		queuedActions:     map[[6]byte]*APAction{},
+		ledState:          map[[6]byte]bool{},
+		pending:           map[[6]byte]*PendingDevice{},
+		approved:          map[[6]byte]bool{},
+		approvals:         make(chan [6]byte, 8),
+		adoptions:         adoptions{byMAC: map[[6]byte]*adoption{}},
+		adoptResults:      make(chan *adoptionResult, 8),
+		forgetting:        map[[6]byte]time.Time{},
+		resetting:         map[[6]byte]bool{},
+		resetResults:      make(chan *resetResult, 8),
+		informURLProblems: map[[6]byte]*InformURLProblem{},
+		localAddr:         strings.Trim(localAddr, "[]"),
+		httpListenerAddr:  httpListenerAddr,
+
+		discoveryInitializer: func(localAddr, listenerAddr string, discoveryPkt *packet.Discovery) (AP, *adopt.Config, error) {
 			discoveryPkt.Debug()
-			adoptCfg := adopt.NewConfig(strings.Split(discoveryPkt.IPInfo.String(), ":")[0]+":22", localAddr+listenerAddr, "ubnt")
+			adoptCfg := adopt.NewConfig(net.JoinHostPort(Host(discoveryPkt.IPInfo.String()), "22"), config.ControllerAddr(localAddr, listenerAddr), "ubnt")
 			return &BasicClient{
 				EncryptionKey: adoptCfg.Key,
 				MACAddr:       discoveryPkt.MAC,
 				IP:            discoveryPkt.IPInfo,
 				Configuration: conf,
 			}, adoptCfg, nil
-		}
-	}
-
-	if apInitializer == nil {
-		m.apDiscoverer = func(ip string, i *packet.Inform) (AP, error) {
+		},
+		apDiscoverer: func(ip string, i *packet.Inform) (AP, error) {
 			return nil, errors.New("Unknown AP " + hex.EncodeToString(i.APMAC[:]) + " on " + ip)
-		}
-	}
-
-	serv, err := serv.New(m, httpListenerAddr)
-	if err != nil {
-		return nil, err
+		},
 	}
+}
 
-	m.serv = serv
+// lookupAP returns the state of the AP with the given MAC address, or nil if it is not known.
+func (m *Manager) lookupAP(mac [6]byte) AP {
+	m.apLock.Lock()
+	defer m.apLock.Unlock()
+	return m.MacAddrToKey[mac]
+}
 
-	return m, nil
+// addAP records the state of an AP.
+func (m *Manager) addAP(accessPoint AP) {
+	m.apLock.Lock()
+	defer m.apLock.Unlock()
+	m.MacAddrToKey[accessPoint.MAC()] = accessPoint
 }
 
 // Close shuts down server resources.
@@ -117,69 +220,121 @@ func (m *Manager) Close() error {
 
 // Run starts the main loop for the manager.
 func (m *Manager) Run() error {
+	adoptionTicker := time.NewTicker(adoptCheckInterval)
+	defer adoptionTicker.Stop()
+
 	for {
 		select {
 		case discoveryPkt := <-m.serv.DiscoveryPackets:
-			_, alreadyAdopted := m.MacAddrToKey[discoveryPkt.MAC]
-			if !alreadyAdopted {
-				accessPoint, adoptCfg, err := m.discoveryInitializer(m.localAddr, m.httpListenerAddr, discoveryPkt)
-				if err != nil {
-					fmt.Printf("[DISCOVERY] State initializer returned error: %s\n", err)
-					fmt.Printf("[DISCOVERY] Aborting processing of discovery from %s\n", discoveryPkt.IPInfo)
-					continue
-				}
-				m.MacAddrToKey[accessPoint.MAC()] = accessPoint
-
-				if adoptCfg == nil {
-					break
-				}
-				setAPConfigDirty(accessPoint)
-				accessPoint.SetState(StateAdopting)
-
-				adoptErr := adopt.Adopt(adoptCfg)
-				if adoptErr != nil {
-					fmt.Printf("[ADOPT] [%x] Adopt failed: %s\n", discoveryPkt.MAC, adoptErr)
-					continue
-				}
-				accessPoint.SetState(StateAdopted)
-				fmt.Printf("[DISCOVERY] [%x] Adoption for %s successful.\n", discoveryPkt.MAC, discoveryPkt.IPInfo)
+			if m.lookupAP(discoveryPkt.MAC) != nil {
+				m.adoptionRediscovered(discoveryPkt.MAC, net.JoinHostPort(Host(discoveryPkt.IPInfo.String()), "22"))
+				continue
 			}
+			if !m.isApproved(discoveryPkt.MAC) {
+				m.addPending(discoveryPkt, false)
+				continue
+			}
+			m.removePending(discoveryPkt.MAC)
+			m.adopt(discoveryPkt, nil)
+
+		case mac := <-m.approvals:
+			if p := m.removePending(mac); p != nil && p.discovery != nil {
+				m.adopt(p.discovery, p.credentials)
+			}
+
+		case r := <-m.adoptResults:
+			m.adoptionAttempted(r)
+
+		case r := <-m.resetResults:
+			m.resetAttempted(r)
+
+		case <-adoptionTicker.C:
+			m.checkAdoptions()
+			m.checkForgets()
 		}
 	}
 }
 
+// adopt initializes state for a discovered AP and adopts it over SSH. If credentials is non-nil, its
+// address, user, password and host key are used instead of those from the state initializer.
+func (m *Manager) adopt(discoveryPkt *packet.Discovery, credentials *adopt.Config) {
+	var apIP net.IP
+	if addr, ok := discoveryPkt.IPInfo.(*net.UDPAddr); ok {
+		apIP = addr.IP
+	}
+	localAddr := m.controllerAddrFor(apIP, discoveryPkt.Interface)
+	accessPoint, adoptCfg, err := m.discoveryInitializer(localAddr, m.httpListenerAddr, discoveryPkt)
+	if err != nil {
+		fmt.Printf("[DISCOVERY] State initializer returned error: %s\n", err)
+		fmt.Printf("[DISCOVERY] Aborting processing of discovery from %s\n", discoveryPkt.IPInfo)
+		return
+	}
+	m.addAP(accessPoint)
+
+	if adoptCfg == nil {
+		return
+	}
+	if credentials != nil {
+		adoptCfg.APAddr = credentials.APAddr
+		adoptCfg.User = credentials.User
+		adoptCfg.Pass = credentials.Pass
+		adoptCfg.HostKey = credentials.HostKey
+	}
+	m.startAdoption(accessPoint, adoptCfg)
+}
+
 // HandleInform is called by the server when an inform packet is recieved.
 func (m *Manager) HandleInform(remoteAddr string, informPkt *packet.Inform) ([]byte, error) {
-	accessPoint, apKnown := m.MacAddrToKey[informPkt.APMAC]
-	if !apKnown {
+	accessPoint := m.lookupAP(informPkt.APMAC)
+	if accessPoint == nil {
 		var err error
 		accessPoint, err = m.apDiscoverer(remoteAddr, informPkt)
 		if err != nil {
-			return nil, err
+			// Factory-default devices inform using the default key, so can be adopted without SSH.
+			reply, adoptErr := m.handleDefaultInform(remoteAddr, informPkt)
+			if adoptErr != nil {
+				fmt.Printf("[INFORM] [%x] Could not adopt via inform: %s\n", informPkt.APMAC, adoptErr)
+				return nil, err
+			}
+			return reply, nil
 		}
-		m.MacAddrToKey[informPkt.APMAC] = accessPoint
+		m.addAP(accessPoint)
+		m.removePending(informPkt.APMAC)
 	}
 
 	d, err := informPkt.Payload(accessPoint.AuthKey())
-	if err != nil {
-		return nil, err
+	var informPayload *packet.InformData
+	if err == nil {
+		informPayload, err = packet.UnpackInform(d)
 	}
-
-	informPayload, err := packet.UnpackInform(d)
 	if err != nil {
-		return nil, err
+		return m.handleUndecryptableInform(remoteAddr, informPkt, accessPoint, err)
 	}
+	m.adoptionComplete(accessPoint.MAC())
+
 	if m.informChan != nil {
 		m.informChan <- informPayload
 	}
+	m.checkInformURL(accessPoint.MAC(), remoteAddr, informPayload.InformURL)
 	//pretty.Print(informPayload)
 
+	if led := accessPoint.GetConfig().LED; led.Mode == config.LEDNight {
+		m.apLock.Lock()
+		pushed, ok := m.ledState[accessPoint.MAC()]
+		m.apLock.Unlock()
+		// If we have not pushed the LED state since starting, the AP may have any state.
+		if !ok || pushed != led.EnabledAt(time.Now()) {
+			fmt.Printf("[INFORM] [%x] LED state is out of date, reprovisioning\n", accessPoint.MAC())
+			setAPConfigDirty(accessPoint)
+		}
+	}
+
 	if informPayload.ConfigVersion != accessPoint.GetConfigVersion() {
 		if accessPoint.GetState() == StateAdopted {
 			accessPoint.SetState(StateProvisioning)
 		}
 		fmt.Printf("[INFORM] [%x] AP config version is %q, but we are at %q\n", accessPoint.MAC(), informPayload.ConfigVersion, accessPoint.GetConfigVersion())
-		return m.handleInformSendConfig(informPayload, informPkt, accessPoint, d)
+		return m.handleInformSendConfig(remoteAddr, informPayload, informPkt, accessPoint, d)
 	}
 
 	if accessPoint.GetState() == StateProvisioning {
@@ -188,20 +343,116 @@ func (m *Manager) HandleInform(remoteAddr string, informPkt *packet.Inform) ([]b
 	return m.handleNormalInform(informPayload, informPkt, accessPoint, d)
 }
 
+// handles an inform from a known AP which cannot be decoded with its key. If the AP has been reset to
+// its factory-default state it must be approved again, and is then adopted via inform. Otherwise
+// re-adoption over SSH is scheduled.
+func (m *Manager) handleUndecryptableInform(remoteAddr string, informPkt *packet.Inform, accessPoint AP, decodeErr error) ([]byte, error) {
+	if d, err := informPkt.Payload(packet.DefaultKey); err == nil {
+		if informPayload, err := packet.UnpackInform(d); err == nil && informPayload.IsDefaultConfig {
+			// Anything can send a default inform with the AP's MAC address, so the previous
+			// approval cannot be trusted.
+			if m.revokeApproval(accessPoint.MAC()) {
+				fmt.Printf("[INFORM] [%x] AP has been reset to factory defaults\n", accessPoint.MAC())
+			}
+			return m.handleDefaultInform(remoteAddr, informPkt)
+		}
+	}
+
+	m.scheduleReadoption(accessPoint)
+	return nil, decodeErr
+}
+
+// handles an inform from an unknown AP in its factory-default state, adopting it by replying with
+// management configuration containing a new authkey and our inform URL. The reply is encrypted with
+// the default key, as the AP does not have the new key yet.
+func (m *Manager) handleDefaultInform(remoteAddr string, informPkt *packet.Inform) ([]byte, error) {
+	d, err := informPkt.Payload(packet.DefaultKey)
+	if err != nil {
+		return nil, err
+	}
+	informPayload, err := packet.UnpackInform(d)
+	if err != nil {
+		return nil, err
+	}
+	if !informPayload.IsDefaultConfig {
+		return nil, errors.New("AP is not in the default state")
+	}
+
+	discoveryPkt := &packet.Discovery{
+		MAC:             informPkt.APMAC,
+		IPInfo:          &net.UDPAddr{IP: net.ParseIP(Host(remoteAddr))},
+		Hostname:        informPayload.Hostname,
+		Platform:        informPayload.Model,
+		FirmwareVersion: informPayload.FirmwareVersion,
+		IsDefault:       true,
+	}
+	if !m.isApproved(informPkt.APMAC) {
+		m.addPending(discoveryPkt, true)
+		return nil, errors.New("awaiting approval for adoption")
+	}
+	m.removePending(informPkt.APMAC)
+	localAddr := m.controllerAddrForRemote(remoteAddr)
+	accessPoint, adoptCfg, err := m.discoveryInitializer(localAddr, m.httpListenerAddr, discoveryPkt)
+	if err != nil {
+		return nil, err
+	}
+	if adoptCfg == nil {
+		return nil, errors.New("state initializer did not provide adoption config")
+	}
+	m.addAP(accessPoint)
+
+	mgmtConf, err := accessPoint.GetConfig().GenerateMgmtConf(hex.EncodeToString(adoptCfg.Key), accessPoint.GetConfigVersion(), localAddr, m.httpListenerAddr)
+	if err != nil {
+		return nil, err
+	}
+	reply, err := informPkt.NewReply()
+	if err != nil {
+		return nil, err
+	}
+	if reply.Data, err = packet.MakeConfigUpdate("", mgmtConf, accessPoint.GetConfigVersion()); err != nil {
+		return nil, err
+	}
+	// The AP reports the version in the reply once it has the management configuration, so it is
+	// changed to have the system configuration pushed too.
+	if err := setAPConfigDirty(accessPoint); err != nil {
+		return nil, err
+	}
+	m.cancelAdoption(accessPoint.MAC())
+	accessPoint.SetState(StateAdopted)
+	fmt.Printf("[INFORM] [%x] Adopting factory-default AP on %s via inform.\n", informPkt.APMAC, remoteAddr)
+	if err := rotateSSHPw(accessPoint); err != nil {
+		fmt.Printf("[INFORM] [%x] Failed to set device credentials: %s\n", informPkt.APMAC, err)
+	}
+	return reply.Marshal(packet.DefaultKey)
+}
+
 // handles an inform packet with a noop when no action needs to be taken.
 func (m *Manager) handleNormalInform(informPayload *packet.InformData, informPkt *packet.Inform, accessPoint AP, d []byte) ([]byte, error) {
-	var err error
-	reply := informPkt.CloneForReply()
+	var forgetAfter string // Set if the AP is no longer ours once the reply is sent.
+	reply, err := informPkt.NewReply()
+	if err != nil {
+		return nil, err
+	}
+
+	m.apLock.Lock()
+	action, ok := m.queuedActions[accessPoint.MAC()]
+	delete(m.queuedActions, accessPoint.MAC())
+	m.apLock.Unlock()
 
-	if action, ok := m.queuedActions[accessPoint.MAC()]; ok {
-		delete(m.queuedActions, accessPoint.MAC())
+	if ok {
 		switch action.Action {
-		case "locate":
-			reply.Data, err = packet.MakeLocate()
-		case "kick":
-			reply.Data, err = packet.MakeKickStation(action.StationMac)
+		case "set-default":
+			reply.Data, err = packet.MakeSetDefault()
+			forgetAfter = action.Action
+		case "set-inform":
+			var mgmtConf string
+			mgmtConf, err = accessPoint.GetConfig().GenerateMgmtConfURL(hex.EncodeToString(accessPoint.AuthKey()), accessPoint.GetConfigVersion(), action.InformURL)
+			if err == nil {
+				reply.Data, err = packet.MakeSetInform(mgmtConf, accessPoint.GetConfigVersion())
+			}
+			forgetAfter = action.Action
 		default:
-			return nil, fmt.Errorf("unknown queued action: %s", action.Action)
+			reply.Data, err = actionCommand(action)
 		}
 	} else {
 		reply.Data, err = packet.MakeNoop(3)
@@ -211,20 +462,32 @@ func (m *Manager) handleNormalInform(informPayload *packet.InformData, informPkt
 		return nil, err
 	}
 	fmt.Printf("[INFORM] [%x] Handled nominal inform\n", accessPoint.MAC())
-	return reply.Marshal(accessPoint.AuthKey())
+	out, err := reply.Marshal(accessPoint.AuthKey())
+	if err == nil && forgetAfter != "" {
+		fmt.Printf("[INFORM] [%x] Sent %s, AP is no longer managed by this controller\n", accessPoint.MAC(), forgetAfter)
+		m.forget(accessPoint)
+	}
+	return out, err
 }
 
 // handles an inform by generating a response to set the configuration.
-func (m *Manager) handleInformSendConfig(informPayload *packet.InformData, informPkt *packet.Inform, accessPoint AP, d []byte) ([]byte, error) {
-	reply := informPkt.CloneForReply()
+func (m *Manager) handleInformSendConfig(remoteAddr string, informPayload *packet.InformData, informPkt *packet.Inform, accessPoint AP, d []byte) ([]byte, error) {
+	reply, err := informPkt.NewReply()
+	if err != nil {
+		return nil, err
+	}
 	fmt.Printf("[INFORM] [%x] Sending system configuration\n", accessPoint.MAC())
-	newSysConf, err := accessPoint.GetConfig().GenerateSysConf(informPayload.ModelName, accessPoint.GetConfigVersion()) //Make modifications based on desired settings
+	cfg := accessPoint.GetConfig()
+	newSysConf, err := cfg.GenerateSysConf(informPayload.ModelName, accessPoint.GetConfigVersion()) //Make modifications based on desired settings
 	if err != nil {
 		return nil, err
 	}
 
 	var mgmtConf string
-	mgmtConf, err = accessPoint.GetConfig().GenerateMgmtConf(hex.EncodeToString(accessPoint.AuthKey()), accessPoint.GetConfigVersion(), m.localAddr, m.httpListenerAddr)
+	m.apLock.Lock()
+	m.ledState[accessPoint.MAC()] = cfg.LED.EnabledAt(time.Now())
+	m.apLock.Unlock()
+	mgmtConf, err = cfg.GenerateMgmtConf(hex.EncodeToString(accessPoint.AuthKey()), accessPoint.GetConfigVersion(), m.controllerAddrForRemote(remoteAddr), m.httpListenerAddr)
 	if err != nil {
 		return nil, err
 	}
@@ -240,31 +503,32 @@ func (m *Manager) handleInformSendConfig(informPayload *packet.InformData, infor
 
 // LocateAP queues a request to switch the AP into locate mode when it next checks in.
 func (m *Manager) LocateAP(mac [6]byte) error {
-	if m.MacAddrToKey[mac] == nil {
-		return errors.New("no such AP")
-	}
-	if m.queuedActions[mac] != nil {
-		return errors.New("a queued event already exists")
-	}
-	m.queuedActions[mac] = &APAction{
-		Action: "locate",
-	}
-	return nil
+	return m.QueueAction(mac, &APAction{Action: "locate"})
 }
 
 // KickStationFromAP queues a request to kick a client/station from the AP.
 func (m *Manager) KickStationFromAP(apMac, stationMac [6]byte) error {
-	if m.MacAddrToKey[apMac] == nil {
+	return m.QueueAction(apMac, &APAction{Action: "kick", StationMac: stationMac})
+}
+
+// Scan broadcasts discovery requests to the given interfaces or subnets (in CIDR notation), or all
+// interfaces if none are given. Devices which respond are handled like any other discovered device.
+func (m *Manager) Scan(targets ...string) error {
+	return m.serv.Probe(targets...)
+}
+
+// RepinHostKey forgets the pinned SSH host key of an AP, such that the key presented on the next
+// connection is trusted. This should be used when the hardware of an AP is replaced.
+func (m *Manager) RepinHostKey(mac [6]byte) error {
+	accessPoint := m.lookupAP(mac)
+	if accessPoint == nil {
 		return errors.New("no such AP")
 	}
-	if m.queuedActions[apMac] != nil {
-		return errors.New("a queued event already exists")
+	pinner, ok := accessPoint.(HostKeyPinner)
+	if !ok {
+		return errors.New("AP does not support host key pinning")
 	}
-	m.queuedActions[apMac] = &APAction{
-		Action:     "kick",
-		StationMac: stationMac,
-	}
-	return nil
+	return pinner.SetHostKey("")
 }
 
 // GenerateRandomBytes returns securely generated random bytes.
@@ -283,6 +547,40 @@ func GenerateRandomBytes(n int) ([]byte, error) {
 	return b, nil
 }
 
+// GeneratePassword returns a random password suitable for device admin credentials.
+func GeneratePassword() (string, error) {
+	b, err := GenerateRandomBytes(18)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// rotateSSHPw generates and stores new SSH credentials for the AP if supported, marking the AP
+// as needing reprovisioning so the new credentials are pushed to it.
+func rotateSSHPw(accessPoint AP) error {
+	c, ok := accessPoint.(CredentialedAP)
+	if !ok {
+		return nil
+	}
+	pw, err := GeneratePassword()
+	if err != nil {
+		return err
+	}
+	if err := c.SetSSHPw(pw); err != nil {
+		return err
+	}
+	return setAPConfigDirty(accessPoint)
+}
+
+// sshUser returns the user to login to the AP as over SSH.
+func sshUser(accessPoint AP) string {
+	if u, ok := accessPoint.(SSHUserAP); ok && u.SSHUser() != "" {
+		return u.SSHUser()
+	}
+	return "ubnt"
+}
+
 func setAPConfigDirty(accessPoint AP) error {
 	r, err := GenerateRandomBytes(8)
 	if err != nil {
@@ -0,0 +1,242 @@
+package manager
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"gofi/config"
+	"gofi/packet"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+)
+
+var (
+	testMAC = [6]byte{0xf0, 0x9f, 0xc2, 0xaa, 0xbb, 0xcc}
+	testKey = bytes.Repeat([]byte{0x42}, 16)
+)
+
+// newTestManager returns a manager which knows about the given APs, and is not listening.
+// Other APs are managed with nightLEDConfig.
+func newTestManager(t testing.TB, aps ...AP) *Manager {
+	m := newManager(":8421", "", nightLEDConfig())
+	for _, ap := range aps {
+		m.MacAddrToKey[ap.MAC()] = ap
+	}
+	return m
+}
+
+// newTestInform returns an inform from the given AP, ready to be encrypted.
+func newTestInform(mac [6]byte) *packet.Inform {
+	return &packet.Inform{APMAC: mac, IV: bytes.Repeat([]byte{0x01}, 16), DataVersion: 1}
+}
+
+// encodeInform returns an inform from the given AP with the given payload, as decoded by the server.
+func encodeInform(t testing.TB, mac [6]byte, key []byte, payload *packet.InformData) *packet.Inform {
+	informPkt, err := packet.InformDecode(bytes.NewReader(marshalInform(t, mac, key, payload)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return informPkt
+}
+
+// marshalInform returns an inform from the given AP with the given payload, as sent by the AP.
+func marshalInform(t testing.TB, mac [6]byte, key []byte, payload *packet.InformData) []byte {
+	informPkt := newTestInform(mac)
+	var err error
+	if informPkt.Data, err = json.Marshal(payload); err != nil {
+		t.Fatal(err)
+	}
+	b, err := informPkt.Marshal(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// nightLEDConfig returns a configuration which turns the LEDs off at night.
+func nightLEDConfig() *config.Config {
+	return &config.Config{
+		Networks: []config.Network{{SSID: "kek", Pass: "the_shrekkening"}},
+		LED:      config.LEDSettings{Mode: config.LEDNight, NightStart: 22, NightEnd: 7},
+	}
+}
+
+// decodeReply decrypts the reply to an inform, returning the command it contains.
+// Timestamps are cleared, so commands can be compared.
+func decodeReply(t *testing.T, out, key []byte) packet.CommandData {
+	reply, err := packet.InformDecode(bytes.NewReader(out))
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := reply.Payload(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var cmd packet.CommandData
+	if err := json.Unmarshal(d, &cmd); err != nil {
+		t.Fatal(err)
+	}
+	cmd.ServerTimestamp, cmd.DatetimeRFC3339, cmd.TimeStr = "", "", ""
+	return cmd
+}
+
+func TestInformReprovisionsUnknownLEDState(t *testing.T) {
+	ap := &BasicClient{MACAddr: testMAC, EncryptionKey: testKey, CfgVersion: "abc", Configuration: nightLEDConfig()}
+	m := newTestManager(t, ap)
+
+	// The LED state pushed before a restart is not known, so the AP is reprovisioned.
+	out, err := m.HandleInform("192.168.1.20:41234", encodeInform(t, testMAC, testKey, &packet.InformData{ModelName: "UAP-AC-LR", ConfigVersion: "abc"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cmd := decodeReply(t, out, testKey); cmd.Type != "setparam" || ap.CfgVersion == "abc" {
+		t.Fatalf("Expected AP to be reprovisioned, got %+v", cmd)
+	}
+
+	out, err = m.HandleInform("192.168.1.20:41234", encodeInform(t, testMAC, testKey, &packet.InformData{ModelName: "UAP-AC-LR", ConfigVersion: ap.CfgVersion}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cmd := decodeReply(t, out, testKey); cmd.Type != "noop" {
+		t.Errorf("Expected noop once LED state is known, got %+v", cmd)
+	}
+}
+
+func TestConcurrentInforms(t *testing.T) {
+	m := newTestManager(t)
+	var aps []*BasicClient
+	for i := 0; i < 8; i++ {
+		ap := &BasicClient{MACAddr: [6]byte{0xf0, 0x9f, 0xc2, 0, 0, byte(i)}, EncryptionKey: testKey, CfgVersion: "abc", Configuration: nightLEDConfig()}
+		m.addAP(ap)
+		aps = append(aps, ap)
+	}
+
+	var wg sync.WaitGroup
+	for _, ap := range aps {
+		wg.Add(2)
+		go func(ap *BasicClient) {
+			defer wg.Done()
+			for i := 0; i < 10; i++ {
+				informPkt := encodeInform(t, ap.MAC(), testKey, &packet.InformData{ModelName: "UAP-AC-LR", ConfigVersion: ap.GetConfigVersion()})
+				if _, err := m.HandleInform("192.168.1.20:41234", informPkt); err != nil {
+					t.Error(err)
+				}
+			}
+		}(ap)
+		go func(mac [6]byte) {
+			defer wg.Done()
+			for i := 0; i < 10; i++ {
+				m.LocateAP(mac)
+				m.RepinHostKey(mac)
+			}
+		}(ap.MAC())
+	}
+	wg.Wait()
+}
+
+func TestAdoptViaDefaultInform(t *testing.T) {
+	// The LEDs are left alone, as an unknown LED state would cause the AP to be reprovisioned anyway.
+	conf := nightLEDConfig()
+	conf.LED = config.LEDSettings{}
+	m := newManager(":8421", "192.168.1.2", conf)
+	defaultInform := &packet.InformData{ModelName: "UAP-AC-LR", Model: "U7LR", IsDefaultConfig: true}
+
+	if _, err := m.HandleInform("192.168.1.20:41234", encodeInform(t, testMAC, packet.DefaultKey, defaultInform)); err == nil {
+		t.Error("Expected error for AP awaiting approval")
+	}
+	if m.lookupAP(testMAC) != nil {
+		t.Fatal("Unapproved AP was adopted")
+	}
+	if p := m.PendingDevices(); len(p) != 1 || !p[0].ViaInform || p[0].Model != "U7LR" {
+		t.Fatalf("Expected AP to await approval, got %+v", p)
+	}
+
+	if err := m.Approve(testMAC); err != nil {
+		t.Fatal(err)
+	}
+	out, err := m.HandleInform("192.168.1.20:41234", encodeInform(t, testMAC, packet.DefaultKey, defaultInform))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ap := m.lookupAP(testMAC)
+	if ap == nil || bytes.Equal(ap.AuthKey(), packet.DefaultKey) {
+		t.Fatal("Expected AP to be adopted with a new key")
+	}
+	// The AP does not have the new key until it has read the reply.
+	cmd := decodeReply(t, out, packet.DefaultKey)
+	if cmd.Type != "setparam" || !strings.Contains(cmd.ManagementConfig, "mgmt.authkey="+hex.EncodeToString(ap.AuthKey())+"\n") ||
+		!strings.Contains(cmd.ManagementConfig, "mgmt.servers.1.url=http://192.168.1.2:8421/inform\n") {
+		t.Errorf("Expected management config with new key and inform URL, got %+v", cmd)
+	}
+	if len(m.PendingDevices()) != 0 {
+		t.Error("Expected AP to no longer be pending")
+	}
+
+	// Subsequent informs use the new key and the version from the reply, and are sent the system configuration.
+	cfgVersion := regexp.MustCompile(`(?m)^cfgversion=(.*)$`).FindStringSubmatch(cmd.ManagementConfig)
+	if cfgVersion == nil {
+		t.Fatalf("Expected cfgversion in management config, got %q", cmd.ManagementConfig)
+	}
+	out, err = m.HandleInform("192.168.1.20:41234", encodeInform(t, testMAC, ap.AuthKey(), &packet.InformData{ModelName: "UAP-AC-LR", ConfigVersion: cfgVersion[1]}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cmd := decodeReply(t, out, ap.AuthKey()); cmd.Type != "setparam" || cmd.SystemConfig == "" {
+		t.Errorf("Expected system configuration, got %+v", cmd)
+	}
+}
+
+func TestResetAPNeedsApproval(t *testing.T) {
+	ap := &BasicClient{MACAddr: testMAC, EncryptionKey: testKey, CfgVersion: "abc", Configuration: nightLEDConfig()}
+	m := newTestManager(t, ap)
+	m.approved[testMAC] = true
+	defaultInform := &packet.InformData{ModelName: "UAP-AC-LR", Model: "U7LR", IsDefaultConfig: true}
+
+	for i := 0; i < 2; i++ {
+		if _, err := m.HandleInform("192.168.1.20:41234", encodeInform(t, testMAC, packet.DefaultKey, defaultInform)); err == nil {
+			t.Error("Expected error for reset AP awaiting approval")
+		}
+	}
+	if m.lookupAP(testMAC) != ap || m.isApproved(testMAC) {
+		t.Fatal("Reset AP was adopted without approval")
+	}
+	if p := m.PendingDevices(); len(p) != 1 || !p[0].ViaInform {
+		t.Fatalf("Expected reset AP to await approval, got %+v", p)
+	}
+
+	if err := m.Approve(testMAC); err != nil {
+		t.Fatal(err)
+	}
+	out, err := m.HandleInform("192.168.1.20:41234", encodeInform(t, testMAC, packet.DefaultKey, defaultInform))
+	if err != nil {
+		t.Fatal(err)
+	}
+	readopted := m.lookupAP(testMAC)
+	if readopted == nil || bytes.Equal(readopted.AuthKey(), packet.DefaultKey) || bytes.Equal(readopted.AuthKey(), testKey) {
+		t.Fatal("Expected AP to be adopted again with a new key")
+	}
+	if cmd := decodeReply(t, out, packet.DefaultKey); !strings.Contains(cmd.ManagementConfig, "mgmt.authkey="+hex.EncodeToString(readopted.AuthKey())+"\n") {
+		t.Errorf("Expected management config with new key, got %+v", cmd)
+	}
+}
+
+func BenchmarkHandleInform(b *testing.B) {
+	ap := &BasicClient{MACAddr: testMAC, EncryptionKey: testKey, CfgVersion: "abc", Configuration: &config.Config{}}
+	m := newTestManager(b, ap)
+	raw := marshalInform(b, testMAC, testKey, &packet.InformData{ModelName: "UAP-AC-LR", ConfigVersion: "abc"})
+	b.ReportAllocs()
+	b.SetBytes(int64(len(raw)))
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		informPkt, err := packet.InformDecode(bytes.NewReader(raw))
+		if err != nil {
+			b.Fatal(err)
+		}
+		if _, err := m.HandleInform("192.168.1.20:41234", informPkt); err != nil {
+			b.Fatal(err)
+		}
+		informPkt.Release()
+	}
+}
@@ -0,0 +1,77 @@
+package manager
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+)
+
+// DeviceRecord describes an adopted device, such that it can be managed by another controller
+// without re-adoption. It contains the device's key and credentials, so should be kept secret.
+type DeviceRecord struct {
+	MAC           string
+	Model         string `json:",omitempty"` // Model of the device, if known.
+	AuthKey       string // Hex-encoded inform key.
+	ConfigVersion string
+	SSHUser       string `json:",omitempty"` // Defaults to ubnt.
+	SSHPw         string
+	HostKey       string `json:",omitempty"`
+}
+
+// Key returns the decoded inform key of the device.
+func (r *DeviceRecord) Key() ([]byte, error) {
+	k, err := hex.DecodeString(r.AuthKey)
+	if err != nil {
+		return nil, err
+	}
+	if len(k) != 16 {
+		return nil, errors.New("invalid key length")
+	}
+	return k, nil
+}
+
+// ExportDevice returns the record of an adopted device, which can be imported by another controller.
+func (m *Manager) ExportDevice(mac [6]byte) (*DeviceRecord, error) {
+	accessPoint := m.lookupAP(mac)
+	if accessPoint == nil {
+		return nil, errors.New("no such AP")
+	}
+	r := &DeviceRecord{
+		MAC:           FormatMAC(mac),
+		AuthKey:       hex.EncodeToString(accessPoint.AuthKey()),
+		ConfigVersion: accessPoint.GetConfigVersion(),
+		SSHUser:       sshUser(accessPoint),
+		SSHPw:         accessPoint.SSHPw(),
+	}
+	if pinner, ok := accessPoint.(HostKeyPinner); ok {
+		r.HostKey = pinner.HostKey()
+	}
+	return r, nil
+}
+
+// Migrate queues a request for an AP to inform to another controller, keeping its current key. Once
+// the new inform URL has been delivered the AP is forgotten. The other controller should import the
+// AP's record (see ExportDevice) beforehand.
+func (m *Manager) Migrate(mac [6]byte, informURL string) error {
+	u, err := url.Parse(informURL)
+	if err != nil {
+		return err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errors.New("inform URL must be an absolute http URL, ie: http://10.0.0.2:8421/inform")
+	}
+	m.apLock.Lock()
+	if m.MacAddrToKey[mac] == nil {
+		m.apLock.Unlock()
+		return errors.New("no such AP")
+	}
+	m.queuedActions[mac] = &APAction{
+		Action:    "set-inform",
+		InformURL: informURL,
+	}
+	m.apLock.Unlock()
+	m.cancelAdoption(mac)
+	fmt.Printf("[MANAGER] [%x] Queued move to %s\n", mac, informURL)
+	return nil
+}
@@ -0,0 +1,89 @@
+package manager
+
+import (
+	"errors"
+	"fmt"
+	"gofi/adopt"
+	"gofi/config"
+	"gofi/packet"
+	"net"
+	"time"
+)
+
+// informPortDefault is the port devices inform on when they find the controller via DHCP or DNS.
+const informPortDefault = "8080"
+
+// RemoteAdoptionHints describes how devices on other subnets (which cannot hear discovery broadcasts)
+// can be pointed at the controller.
+type RemoteAdoptionHints struct {
+	InformURL    string
+	DHCPOption43 string `json:",omitempty"` // Hex-encoded value of DHCP option 43, IPv4 only.
+	DNSRecord    string // Record devices resolve to find the controller (in their search domain).
+	Warning      string `json:",omitempty"`
+}
+
+// RemoteAdoptionHints returns the DHCP and DNS configuration which points devices at this controller.
+func (m *Manager) RemoteAdoptionHints() (*RemoteAdoptionHints, error) {
+	var err error
+	localAddr := m.controllerAddrFor(nil, "")
+	ip := net.ParseIP(localAddr)
+	if ip == nil {
+		return nil, errors.New("controller address " + localAddr + " is not an IP address")
+	}
+	h := &RemoteAdoptionHints{
+		InformURL: config.InformURL(localAddr, m.httpListenerAddr),
+		DNSRecord: "unifi. IN A " + ip.String(),
+	}
+	if ip.To4() == nil {
+		// DHCP option 43 only carries IPv4 addresses.
+		h.DNSRecord = "unifi. IN AAAA " + ip.String()
+	} else if h.DHCPOption43, err = adopt.DHCPOption43(ip); err != nil {
+		return nil, err
+	}
+	if _, port, err := net.SplitHostPort(m.httpListenerAddr); err != nil || port != informPortDefault {
+		h.Warning = fmt.Sprintf("devices found via DHCP or DNS inform on port %s, but the controller listens on %q", informPortDefault, m.httpListenerAddr)
+	}
+	return h, nil
+}
+
+// AdoptAt adopts the device at the given address over SSH, using the given credentials. This is used
+// for devices which are on a different subnet, and hence cannot be discovered. The device is considered
+// approved for adoption.
+func (m *Manager) AdoptAt(addr, user, pass string) error {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		host, port = addr, "22"
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return errors.New("invalid IP address " + host)
+	}
+
+	cfg := &adopt.Config{APAddr: net.JoinHostPort(host, port), User: user, Pass: pass}
+	mac, err := adopt.DeviceMAC(cfg)
+	if err != nil {
+		return err
+	}
+	if m.lookupAP(mac) != nil {
+		return errors.New("device " + FormatMAC(mac) + " is already adopted")
+	}
+	fmt.Printf("[ADOPT] [%x] Found device at %s\n", mac, cfg.APAddr)
+
+	discoveryPkt := &packet.Discovery{
+		MAC:    mac,
+		IPInfo: &net.UDPAddr{IP: ip},
+	}
+	m.pendingLock.Lock()
+	m.approved[mac] = true
+	m.pending[mac] = &PendingDevice{
+		MAC:         FormatMAC(mac),
+		IP:          ip.String(),
+		LastSeen:    time.Now(),
+		discovery:   discoveryPkt,
+		credentials: cfg,
+	}
+	m.pendingLock.Unlock()
+
+	m.approvals <- mac
+	return nil
+}
@@ -2,16 +2,15 @@ package manager
 
 import (
 	"fmt"
+	"gofi/adopt"
+	"net"
 
 	"golang.org/x/crypto/ssh"
 )
 
 // NOTE: Deprecated method. Don't do this.
-func applyConfig(addr, pass string) error {
-	c := &ssh.ClientConfig{User: "ubnt", HostKeyCallback: ssh.InsecureIgnoreHostKey()}
-	c.Auth = append(c.Auth, ssh.Password(pass))
-
-	client, err := ssh.Dial("tcp", addr+":22", c)
+func applyConfig(addr, user, pass string, hostKey *string) error {
+	client, err := ssh.Dial("tcp", net.JoinHostPort(addr, "22"), adopt.ClientConfig(user, pass, hostKey))
 	if err != nil {
 		return err
 	}
@@ -41,11 +40,8 @@ func applyConfig(addr, pass string) error {
 }
 
 // NOTE: Deprecated method. Don't do this.
-func setSystemConfig(addr, pass, cfg string) error {
-	c := &ssh.ClientConfig{User: "ubnt", HostKeyCallback: ssh.InsecureIgnoreHostKey()}
-	c.Auth = append(c.Auth, ssh.Password(pass))
-
-	client, err := ssh.Dial("tcp", addr+":22", c)
+func setSystemConfig(addr, user, pass, cfg string, hostKey *string) error {
+	client, err := ssh.Dial("tcp", net.JoinHostPort(addr, "22"), adopt.ClientConfig(user, pass, hostKey))
 	if err != nil {
 		return err
 	}
@@ -67,29 +63,28 @@ func setSystemConfig(addr, pass, cfg string) error {
 	return s.Run("cat - > /tmp/system.cfg")
 }
 
-// GetSysConfig returns the currently applied system configuration.
+// GetSysConfig logs in with the given credentials, and returns the currently applied system
+// configuration and the host key of the device. The host key is verified against hostKey, or
+// trusted if hostKey is empty.
 // Probably dont use this approach.
-func GetSysConfig(addr, pass string) ([]byte, error) {
-	c := &ssh.ClientConfig{User: "ubnt", HostKeyCallback: ssh.InsecureIgnoreHostKey()}
-	c.Auth = append(c.Auth, ssh.Password(pass))
-
-	client, err := ssh.Dial("tcp", addr+":22", c)
+func GetSysConfig(addr, user, pass, hostKey string) ([]byte, string, error) {
+	client, err := ssh.Dial("tcp", net.JoinHostPort(addr, "22"), adopt.ClientConfig(user, pass, &hostKey))
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 	defer client.Close()
 
 	s, err := client.NewSession()
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 
 	var system []byte
 	system, err = s.Output("/bin/cat /tmp/system.cfg")
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 	s.Close()
 
-	return system, nil
+	return system, hostKey, nil
 }
@@ -0,0 +1,132 @@
+package packet
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"time"
+)
+
+// now is overridden in tests.
+var now = time.Now
+
+// command returns a cmd message timestamped with the current time.
+func command(cmd string) CommandData {
+	t := now()
+	return CommandData{
+		Type:            "cmd",
+		Cmd:             cmd,
+		ServerTimestamp: unixMicroPSTString(),
+		DatetimeRFC3339: t.Format(time.RFC3339),
+		TimeStr:         fmt.Sprint(t.Unix()),
+	}
+}
+
+// stationCommand returns a cmd message which acts on the given station.
+func stationCommand(cmd string, mac [6]byte) CommandData {
+	c := command(cmd)
+	c.MacAddr = net.HardwareAddr(mac[:]).String()
+	return c
+}
+
+// MakeReboot creates the payload section of a reboot command.
+func MakeReboot() ([]byte, error) {
+	return json.Marshal(command("reboot"))
+}
+
+// MakeUnsetLocate creates the payload section of a command which takes the AP out of locate mode.
+func MakeUnsetLocate() ([]byte, error) {
+	return json.Marshal(command("unset-locate"))
+}
+
+// MakeUpgrade creates the payload section of a firmware upgrade. The AP downloads the firmware from
+// firmwareURL, and only installs it if its MD5 checksum matches md5sum. version is optional.
+func MakeUpgrade(firmwareURL, md5sum, version string) ([]byte, error) {
+	u, err := url.Parse(firmwareURL)
+	if err != nil {
+		return nil, err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, errors.New("firmware URL must be an absolute http(s) URL")
+	}
+	if sum, err := hex.DecodeString(md5sum); err != nil || len(sum) != 16 {
+		return nil, errors.New("invalid MD5 checksum " + md5sum)
+	}
+
+	cmd := command("")
+	cmd.Type = "upgrade"
+	cmd.URL = firmwareURL
+	cmd.MD5Sum = md5sum
+	cmd.Version = version
+	return json.Marshal(cmd)
+}
+
+// MakeSetInform creates the payload section of a response which only sets the management
+// configuration, such as the inform URL and key.
+func MakeSetInform(mgmtCfg, configVersion string) ([]byte, error) {
+	return MakeConfigUpdate("", mgmtCfg, configVersion)
+}
+
+// MakeAuthorizeGuest creates the payload section of a command which lets a station through the
+// guest portal for the given number of minutes. Zero rates or quota mean unlimited.
+func MakeAuthorizeGuest(mac [6]byte, minutes, upKbps, downKbps, quotaMB int) ([]byte, error) {
+	if minutes <= 0 {
+		return nil, errors.New("guest authorization must last at least a minute")
+	}
+	if upKbps < 0 || downKbps < 0 || quotaMB < 0 {
+		return nil, errors.New("guest limits cannot be negative")
+	}
+	cmd := stationCommand("authorize-guest", mac)
+	cmd.Minutes = minutes
+	cmd.UpKbps, cmd.DownKbps, cmd.QuotaMB = upKbps, downKbps, quotaMB
+	return json.Marshal(cmd)
+}
+
+// MakeUnauthorizeGuest creates the payload section of a command which revokes a station's guest access.
+func MakeUnauthorizeGuest(mac [6]byte) ([]byte, error) {
+	return json.Marshal(stationCommand("unauthorize-guest", mac))
+}
+
+// MakeBlockStation creates the payload section of a command which stops a station associating.
+func MakeBlockStation(mac [6]byte) ([]byte, error) {
+	return json.Marshal(stationCommand("block-sta", mac))
+}
+
+// MakeUnblockStation creates the payload section of a command which allows a blocked station to associate.
+func MakeUnblockStation(mac [6]byte) ([]byte, error) {
+	return json.Marshal(stationCommand("unblock-sta", mac))
+}
+
+// MakeSpectrumScan creates the payload section of a command which starts a spectrum scan on the
+// given radio (ng or na), or all radios if radio is empty. Clients are disconnected during the scan.
+func MakeSpectrumScan(radio string) ([]byte, error) {
+	if radio != "" && radio != "ng" && radio != "na" {
+		return nil, errors.New("unknown radio " + radio)
+	}
+	cmd := command("spectrum-scan")
+	cmd.Radio = radio
+	return json.Marshal(cmd)
+}
+
+// MakeCableTest creates the payload section of a command which tests the cable on a switch port.
+func MakeCableTest(port int) ([]byte, error) {
+	return portCommand("cable-test", port)
+}
+
+// MakePowerCyclePort creates the payload section of a command which turns PoE on a switch port
+// off and on again, rebooting the device it powers.
+func MakePowerCyclePort(port int) ([]byte, error) {
+	return portCommand("power-cycle", port)
+}
+
+func portCommand(cmd string, port int) ([]byte, error) {
+	if port < 1 {
+		return nil, fmt.Errorf("invalid port %d", port)
+	}
+	c := command(cmd)
+	c.Port = port
+	return json.Marshal(c)
+}
@@ -0,0 +1,86 @@
+package packet
+
+import (
+	"testing"
+	"time"
+)
+
+// cmdJSON returns the JSON of a cmd message at the time used by the tests, followed by fields.
+func cmdJSON(cmd, fields string) string {
+	return `{"_type":"cmd","server_time_in_utc":"1577934245000","cmd":"` + cmd +
+		`","datetime":"2020-01-02T03:04:05Z","time":"1577934245"` + fields + `}`
+}
+
+func TestCommandsGolden(t *testing.T) {
+	defer func(n func() time.Time) { now = n }(now)
+	now = func() time.Time { return time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC) }
+	sta := [6]byte{0x80, 0x2a, 0xa8, 0x11, 0x22, 0x33}
+	mgmtCfg := "mgmt.servers.1.url=http://10.0.0.2:8421/inform\n"
+
+	for _, tc := range []struct {
+		name     string
+		make     func() ([]byte, error)
+		expected string
+	}{
+		{"noop", func() ([]byte, error) { return MakeNoop(3) },
+			`{"_type":"noop","interval":3,"blocked_sta":""}`},
+		{"locate", MakeLocate,
+			cmdJSON("locate", `,"blocked_sta":""`)},
+		{"unset-locate", MakeUnsetLocate,
+			cmdJSON("unset-locate", `,"blocked_sta":""`)},
+		{"reboot", MakeReboot,
+			cmdJSON("reboot", `,"blocked_sta":""`)},
+		{"set-default", MakeSetDefault,
+			cmdJSON("set-default", `,"blocked_sta":""`)},
+		{"upgrade", func() ([]byte, error) {
+			return MakeUpgrade("https://dl.example.com/U7PG2/4.3.20.bin", "8b3c9d0e0a9f6e3f1b2a3c4d5e6f7a8b", "4.3.20.11298")
+		}, `{"_type":"upgrade","server_time_in_utc":"1577934245000","datetime":"2020-01-02T03:04:05Z","time":"1577934245","blocked_sta":"","url":"https://dl.example.com/U7PG2/4.3.20.bin","md5sum":"8b3c9d0e0a9f6e3f1b2a3c4d5e6f7a8b","version":"4.3.20.11298"}`},
+		{"set-inform", func() ([]byte, error) { return MakeSetInform(mgmtCfg, "abc") },
+			`{"_type":"setparam","server_time_in_utc":"1577934245000","cfgversion":"abc","mgmt_cfg":"mgmt.servers.1.url=http://10.0.0.2:8421/inform\n","blocked_sta":""}`},
+		{"kick-sta", func() ([]byte, error) { return MakeKickStation(sta) },
+			cmdJSON("kick-sta", `,"mac":"80:2a:a8:11:22:33","blocked_sta":""`)},
+		{"authorize-guest", func() ([]byte, error) { return MakeAuthorizeGuest(sta, 60, 1000, 5000, 100) },
+			cmdJSON("authorize-guest", `,"mac":"80:2a:a8:11:22:33","blocked_sta":"","minutes":60,"up":1000,"down":5000,"bytes":100`)},
+		{"unauthorize-guest", func() ([]byte, error) { return MakeUnauthorizeGuest(sta) },
+			cmdJSON("unauthorize-guest", `,"mac":"80:2a:a8:11:22:33","blocked_sta":""`)},
+		{"block-sta", func() ([]byte, error) { return MakeBlockStation(sta) },
+			cmdJSON("block-sta", `,"mac":"80:2a:a8:11:22:33","blocked_sta":""`)},
+		{"unblock-sta", func() ([]byte, error) { return MakeUnblockStation(sta) },
+			cmdJSON("unblock-sta", `,"mac":"80:2a:a8:11:22:33","blocked_sta":""`)},
+		{"spectrum-scan", func() ([]byte, error) { return MakeSpectrumScan("na") },
+			cmdJSON("spectrum-scan", `,"blocked_sta":"","radio":"na"`)},
+		{"cable-test", func() ([]byte, error) { return MakeCableTest(3) },
+			cmdJSON("cable-test", `,"blocked_sta":"","port_idx":3`)},
+		{"power-cycle", func() ([]byte, error) { return MakePowerCyclePort(8) },
+			cmdJSON("power-cycle", `,"blocked_sta":"","port_idx":8`)},
+	} {
+		out, err := tc.make()
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+		if string(out) != tc.expected {
+			t.Errorf("%s:\nexpected %s\n     got %s", tc.name, tc.expected, out)
+		}
+	}
+}
+
+func TestCommandsRejectInvalidArguments(t *testing.T) {
+	sta := [6]byte{0x80, 0x2a, 0xa8, 0x11, 0x22, 0x33}
+	for name, make := range map[string]func() ([]byte, error){
+		"relative URL": func() ([]byte, error) { return MakeUpgrade("/fw.bin", "8b3c9d0e0a9f6e3f1b2a3c4d5e6f7a8b", "") },
+		"ftp URL": func() ([]byte, error) {
+			return MakeUpgrade("ftp://example.com/fw.bin", "8b3c9d0e0a9f6e3f1b2a3c4d5e6f7a8b", "")
+		},
+		"short checksum":    func() ([]byte, error) { return MakeUpgrade("http://example.com/fw.bin", "8b3c", "") },
+		"zero minutes":      func() ([]byte, error) { return MakeAuthorizeGuest(sta, 0, 0, 0, 0) },
+		"negative rate":     func() ([]byte, error) { return MakeAuthorizeGuest(sta, 10, -1, 0, 0) },
+		"unknown radio":     func() ([]byte, error) { return MakeSpectrumScan("6e") },
+		"port zero":         func() ([]byte, error) { return MakeCableTest(0) },
+		"negative PoE port": func() ([]byte, error) { return MakePowerCyclePort(-1) },
+	} {
+		if _, err := make(); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
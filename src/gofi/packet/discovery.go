@@ -17,25 +17,84 @@ const (
 	Uptime          uint8 = 0xA
 	Hostname        uint8 = 0x0B
 	Platform        uint8 = 0x0C
+	ESSID           uint8 = 0x0D
+	WirelessMode    uint8 = 0x0E
+	WebPort         uint8 = 0x0F
+	Sequence        uint8 = 0x12
+	Model           uint8 = 0x14
+	ShortModel      uint8 = 0x15
+	IsDefault       uint8 = 0x17
+	Locating        uint8 = 0x18
+	SSHPort         uint8 = 0x1C
 )
 
+// Discovery protocol versions.
+const (
+	DiscoveryV1 uint8 = 1 // Replies to a ProbeRequest, sent by older firmware.
+	DiscoveryV2 uint8 = 2 // Periodic announcements.
+)
+
+// ProbeRequest is the payload of a discovery request. Devices which receive it reply with a discovery packet.
+var ProbeRequest = []byte{1, 0, 0, 0}
+
 // ErrDiscoveryIncorrectHeader is returned if a packet is not a discovery packet.
 var ErrDiscoveryIncorrectHeader = errors.New("incorrect header")
 
+// ErrDiscoveryProbe is returned if a packet is a ProbeRequest, rather than a discovery packet.
+var ErrDiscoveryProbe = errors.New("packet is a discovery request")
+
 // Discovery represents the information in a discovery packet.
-// Not all fields implemented.
 type Discovery struct {
+	Version uint8
 	PktSize uint16
 	RawTLVs []*TLV `json:"-"`
 
-	MAC      [6]byte
-	Hostname string
-	IPInfo   net.Addr
+	MAC       [6]byte
+	Hostname  string
+	IPInfo    net.Addr        // Address the packet was received from.
+	Interface string          // Local interface the packet arrived on, if known.
+	Addrs     []DiscoveryAddr // Addresses reported by the device.
 
 	Platform        string
+	Model           string
+	ShortModel      string
 	FirmwareVersion string
 
-	UptimeSecs uint32
+	UptimeSecs   uint32
+	Sequence     uint32
+	ESSID        string
+	WirelessMode uint8
+	WebPort      uint16
+	SSHPort      uint16
+	IsDefault    bool
+	Locating     bool
+
+	// Unknown holds TLVs which were not decoded into a field.
+	Unknown []*TLV
+}
+
+// DiscoveryAddr is an interface address reported in an IPInfo TLV.
+type DiscoveryAddr struct {
+	MAC [6]byte
+	IP  net.IP
+}
+
+// Managed returns true if the device has been adopted by a controller.
+func (d *Discovery) Managed() bool {
+	return !d.IsDefault
+}
+
+// tlvUint decodes a big-endian integer TLV of 1, 2 or 4 bytes.
+func tlvUint(tlv *TLV) (uint32, error) {
+	switch len(tlv.Payload) {
+	case 1:
+		return uint32(tlv.Payload[0]), nil
+	case 2:
+		return uint32(binary.BigEndian.Uint16(tlv.Payload)), nil
+	case 4:
+		return binary.BigEndian.Uint32(tlv.Payload), nil
+	}
+	return 0, fmt.Errorf("invalid length %d for TLV %d", len(tlv.Payload), tlv.Kind)
 }
 
 // Called internally to parse raw TLV values into fields in the Discover struct.
@@ -48,6 +107,13 @@ func (d *Discovery) unpack() error {
 				return errors.New("Invalid MAC payload length")
 			}
 			copy(d.MAC[:], tlv.Payload[:6])
+		case IPInfo:
+			if tlv.Length != 10 {
+				return errors.New("Invalid IPInfo payload length")
+			}
+			addr := DiscoveryAddr{IP: net.IP(append([]byte{}, tlv.Payload[6:]...))}
+			copy(addr.MAC[:], tlv.Payload[:6])
+			d.Addrs = append(d.Addrs, addr)
 		case Uptime:
 			if err := binary.Read(bytes.NewBuffer(tlv.Payload), binary.BigEndian, &d.UptimeSecs); err != nil {
 				return err
@@ -58,6 +124,34 @@ func (d *Discovery) unpack() error {
 			d.FirmwareVersion = string(tlv.Payload)
 		case Platform:
 			d.Platform = string(tlv.Payload)
+		case Model:
+			d.Model = string(tlv.Payload)
+		case ShortModel:
+			d.ShortModel = string(tlv.Payload)
+		case ESSID:
+			d.ESSID = string(tlv.Payload)
+		case Sequence, WirelessMode, WebPort, SSHPort, IsDefault, Locating:
+			v, err := tlvUint(tlv)
+			if err != nil {
+				return err
+			}
+			switch tlv.Kind {
+			case Sequence:
+				d.Sequence = v
+			case WirelessMode:
+				d.WirelessMode = uint8(v)
+			case WebPort:
+				// Some firmware sends the port in the low half of a 4 byte value.
+				d.WebPort = uint16(v)
+			case SSHPort:
+				d.SSHPort = uint16(v)
+			case IsDefault:
+				d.IsDefault = v != 0
+			case Locating:
+				d.Locating = v != 0
+			}
+		default:
+			d.Unknown = append(d.Unknown, tlv)
 		}
 	}
 	return nil
@@ -70,27 +164,48 @@ func (d *Discovery) Debug() {
 	fmt.Printf("\tHostname=%s\n", d.Hostname)
 	fmt.Printf("\tFirmware=%s\n", d.FirmwareVersion)
 	fmt.Printf("\tPlatform=%s\n", d.Platform)
+	fmt.Printf("\tModel=%s (%s)\n", d.Model, d.ShortModel)
 	fmt.Printf("\tUptime=%d\n", d.UptimeSecs)
-	fmt.Printf("\tAddr=%+v\n", d.IPInfo)
+	fmt.Printf("\tAddr=%+v (%s)\n", d.IPInfo, d.Interface)
+	for _, a := range d.Addrs {
+		fmt.Printf("\tIP=%s (%x)\n", a.IP, a.MAC)
+	}
+	fmt.Printf("\tESSID=%q WirelessMode=%d\n", d.ESSID, d.WirelessMode)
+	fmt.Printf("\tDefault=%v Locating=%v SSHPort=%d WebPort=%d Seq=%d\n", d.IsDefault, d.Locating, d.SSHPort, d.WebPort, d.Sequence)
+	for _, tlv := range d.Unknown {
+		fmt.Printf("\tTLV %d=%x\n", tlv.Kind, tlv.Payload)
+	}
 }
 
 // DiscoveryDecode decodes a discovery packet from a ubiquiti device.
 func DiscoveryDecode(addr *net.UDPAddr, pkt []byte) (*Discovery, error) {
 	r := bytes.NewBuffer(pkt)
-	out := Discovery{IPInfo: addr}
+	var out Discovery
+	if addr != nil {
+		out.IPInfo = addr
+	}
 
 	magic := make([]byte, 2)
 	n, err := io.ReadFull(r, magic)
 	if n != 2 || err != nil {
 		return nil, errors.New("could not read magic header")
 	}
-	if magic[0] != 2 || magic[1] != 6 {
+	// Version 1 packets (replies to a ProbeRequest) have a 01 00 header. Version 2
+	// announcements have a 02 06, 02 09 or 02 0b header depending on the firmware.
+	switch {
+	case magic[0] == DiscoveryV1 && magic[1] == 0:
+	case magic[0] == DiscoveryV2 && (magic[1] == 6 || magic[1] == 9 || magic[1] == 0x0b):
+	default:
 		return nil, ErrDiscoveryIncorrectHeader
 	}
+	out.Version = magic[0]
 
 	if pktSizeErr := binary.Read(r, binary.BigEndian, &out.PktSize); pktSizeErr != nil {
 		return nil, pktSizeErr
 	}
+	if magic[0] == DiscoveryV1 && out.PktSize == 0 {
+		return nil, ErrDiscoveryProbe
+	}
 
 	var tlv *TLV
 	for err == nil {
@@ -109,6 +224,113 @@ func DiscoveryDecode(addr *net.UDPAddr, pkt []byte) (*Discovery, error) {
 	return &out, nil
 }
 
+// Marshal encodes the discovery packet in the form sent by devices announcing themselves,
+// or in the form of a reply to a ProbeRequest if Version is DiscoveryV1. If Addrs is empty
+// and IPInfo is a *net.UDPAddr with an IPv4 address, an IPInfo TLV is included for it.
+func (d *Discovery) Marshal() ([]byte, error) {
+	var w TLVWriter
+	w.Write(MAC, d.MAC[:])
+	addrs := d.Addrs
+	if addr, ok := d.IPInfo.(*net.UDPAddr); ok && addr.IP.To4() != nil && len(addrs) == 0 {
+		addrs = []DiscoveryAddr{{MAC: d.MAC, IP: addr.IP}}
+	}
+	for _, a := range addrs {
+		if a.IP.To4() == nil {
+			return nil, fmt.Errorf("cannot encode non-IPv4 address %s", a.IP)
+		}
+		w.Write(IPInfo, append(a.MAC[:], a.IP.To4()...))
+	}
+	if d.FirmwareVersion != "" {
+		w.Write(FirmwareVersion, []byte(d.FirmwareVersion))
+	}
+	var uptime [4]byte
+	binary.BigEndian.PutUint32(uptime[:], d.UptimeSecs)
+	w.Write(Uptime, uptime[:])
+	if d.Hostname != "" {
+		w.Write(Hostname, []byte(d.Hostname))
+	}
+	if d.Platform != "" {
+		w.Write(Platform, []byte(d.Platform))
+	}
+	if d.ESSID != "" {
+		w.Write(ESSID, []byte(d.ESSID))
+	}
+	if d.WirelessMode != 0 {
+		w.Write(WirelessMode, []byte{d.WirelessMode})
+	}
+	var port [2]byte
+	if d.WebPort != 0 {
+		binary.BigEndian.PutUint16(port[:], d.WebPort)
+		w.Write(WebPort, port[:])
+	}
+	if d.Sequence != 0 {
+		var seq [4]byte
+		binary.BigEndian.PutUint32(seq[:], d.Sequence)
+		w.Write(Sequence, seq[:])
+	}
+	if d.Model != "" {
+		w.Write(Model, []byte(d.Model))
+	}
+	if d.ShortModel != "" {
+		w.Write(ShortModel, []byte(d.ShortModel))
+	}
+	w.Write(IsDefault, []byte{boolByte(d.IsDefault)})
+	if d.Locating {
+		w.Write(Locating, []byte{1})
+	}
+	if d.SSHPort != 0 {
+		binary.BigEndian.PutUint16(port[:], d.SSHPort)
+		w.Write(SSHPort, port[:])
+	}
+	for _, tlv := range d.Unknown {
+		w.Write(tlv.Kind, tlv.Payload)
+	}
+	tlvs, err := w.Bytes()
+	if err != nil {
+		return nil, err
+	}
+	if len(tlvs) > 0xffff {
+		return nil, errors.New("discovery packet too large")
+	}
+
+	out := make([]byte, 4, 4+len(tlvs))
+	out[0], out[1] = DiscoveryV2, 6
+	if d.Version == DiscoveryV1 {
+		out[0], out[1] = DiscoveryV1, 0
+	}
+	binary.BigEndian.PutUint16(out[2:], uint16(len(tlvs)))
+	return append(out, tlvs...), nil
+}
+
+func boolByte(b bool) byte {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// TLVWriter encodes a sequence of type-length-value blocks.
+type TLVWriter struct {
+	buf bytes.Buffer
+	err error
+}
+
+// Write appends a TLV with the given type and payload. Errors are sticky, and returned by Bytes.
+func (w *TLVWriter) Write(kind uint8, payload []byte) {
+	if len(payload) > 0xffff {
+		w.err = fmt.Errorf("TLV %d payload too large (%d bytes)", kind, len(payload))
+		return
+	}
+	w.buf.WriteByte(kind)
+	binary.Write(&w.buf, binary.BigEndian, uint16(len(payload)))
+	w.buf.Write(payload)
+}
+
+// Bytes returns the encoded TLVs.
+func (w *TLVWriter) Bytes() ([]byte, error) {
+	return w.buf.Bytes(), w.err
+}
+
 // TLV is a decoded type-length-value block.
 type TLV struct {
 	Kind    uint8
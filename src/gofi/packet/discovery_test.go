@@ -0,0 +1,211 @@
+package packet
+
+import (
+	"encoding/hex"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDiscoveryRoundTrip(t *testing.T) {
+	addr := &net.UDPAddr{IP: net.ParseIP("192.168.1.20").To4(), Port: 10001}
+	in := &Discovery{
+		MAC:             [6]byte{0xf0, 0x9f, 0xc2, 0xaa, 0xbb, 0xcc},
+		Hostname:        "UBNT",
+		IPInfo:          addr,
+		Addrs:           []DiscoveryAddr{{MAC: [6]byte{0xf0, 0x9f, 0xc2, 0xaa, 0xbb, 0xcc}, IP: net.IP{10, 0, 0, 5}}},
+		Platform:        "U7LR",
+		Model:           "UAP-AC-LR",
+		ShortModel:      "U7LR",
+		FirmwareVersion: "BZ.ar7240.v3.9.3.7537.180510.1541",
+		UptimeSecs:      86400,
+		Sequence:        7,
+		ESSID:           "gofi",
+		WirelessMode:    3,
+		WebPort:         443,
+		SSHPort:         22,
+		Locating:        true,
+		Unknown:         []*TLV{{Kind: 0x16, Length: 6, Payload: []byte("3.9.3.")}},
+	}
+	for _, version := range []uint8{DiscoveryV1, DiscoveryV2} {
+		in.Version = version
+		raw, err := in.Marshal()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		out, err := DiscoveryDecode(addr, raw)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if int(out.PktSize) != len(raw)-4 {
+			t.Errorf("PktSize = %d, expected %d", out.PktSize, len(raw)-4)
+		}
+		out.PktSize, out.RawTLVs = 0, nil
+		if !reflect.DeepEqual(in, out) {
+			t.Errorf("v%d: expected %+v, got %+v", version, in, out)
+		}
+	}
+}
+
+// handAssembledDiscoveries holds packets of both protocol versions, one TLV per line. They were
+// assembled by hand from the TLV layout the parser implements, so check the decoding of each
+// field but not that the TLV types match what devices send.
+var handAssembledDiscoveries = []struct {
+	name string
+	raw  string
+	want Discovery
+}{
+	{
+		name: "v2 announcement",
+		raw: `020600a5
+			010006f09fc2aabbcc
+			02000af09fc2aabbccc0a80114
+			030024425a2e716361393536782e76342e302e38302e31303837352e3230303131312e32333335
+			0a000400015180
+			0b00054c6f626279
+			0c000455374c54
+			0d0004676f6669
+			0e000103
+			100002e517
+			12000400000029
+			130006f09fc2aabbcc
+			14000b5541502d41432d4c697465
+			15000455374c54
+			160006342e302e3830
+			17000100
+			18000100
+			1c00020016
+			0f000400010050`,
+		want: Discovery{
+			Version:         DiscoveryV2,
+			PktSize:         0xa5,
+			MAC:             [6]byte{0xf0, 0x9f, 0xc2, 0xaa, 0xbb, 0xcc},
+			Hostname:        "Lobby",
+			Addrs:           []DiscoveryAddr{{MAC: [6]byte{0xf0, 0x9f, 0xc2, 0xaa, 0xbb, 0xcc}, IP: net.IP{192, 168, 1, 20}}},
+			Platform:        "U7LT",
+			Model:           "UAP-AC-Lite",
+			ShortModel:      "U7LT",
+			FirmwareVersion: "BZ.qca956x.v4.0.80.10875.200111.2335",
+			UptimeSecs:      86400,
+			Sequence:        41,
+			ESSID:           "gofi",
+			WirelessMode:    3,
+			WebPort:         80,
+			SSHPort:         22,
+			Unknown: []*TLV{
+				{Kind: 0x10, Length: 2, Payload: []byte{0xe5, 0x17}},
+				{Kind: 0x13, Length: 6, Payload: []byte{0xf0, 0x9f, 0xc2, 0xaa, 0xbb, 0xcc}},
+				{Kind: 0x16, Length: 6, Payload: []byte("4.0.80")},
+			},
+		},
+	},
+	{
+		name: "v1 probe reply",
+		raw: `01000063
+			0100060418d6112233
+			02000a0418d6112233c0a8010a
+			02000a0618d6112233a9fe2233
+			0a00040000003c
+			0b000455424e54
+			0c0003554150
+			030021425a2e6172373234302e76332e392e332e373533372e3138303531302e31353431
+			0e000102
+			17000101`,
+		want: Discovery{
+			Version:  DiscoveryV1,
+			PktSize:  0x63,
+			MAC:      [6]byte{0x04, 0x18, 0xd6, 0x11, 0x22, 0x33},
+			Hostname: "UBNT",
+			Addrs: []DiscoveryAddr{
+				{MAC: [6]byte{0x04, 0x18, 0xd6, 0x11, 0x22, 0x33}, IP: net.IP{192, 168, 1, 10}},
+				{MAC: [6]byte{0x06, 0x18, 0xd6, 0x11, 0x22, 0x33}, IP: net.IP{169, 254, 34, 51}},
+			},
+			Platform:        "UAP",
+			FirmwareVersion: "BZ.ar7240.v3.9.3.7537.180510.1541",
+			UptimeSecs:      60,
+			WirelessMode:    2,
+			IsDefault:       true,
+		},
+	},
+}
+
+func TestDiscoveryDecodeHandAssembled(t *testing.T) {
+	for _, tc := range handAssembledDiscoveries {
+		raw, err := hex.DecodeString(strings.Join(strings.Fields(tc.raw), ""))
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		d, err := DiscoveryDecode(nil, raw)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		d.RawTLVs = nil
+		if !reflect.DeepEqual(*d, tc.want) {
+			t.Errorf("%s: expected %+v, got %+v", tc.name, tc.want, *d)
+		}
+		if d.Managed() == tc.want.IsDefault {
+			t.Errorf("%s: Managed() = %v, expected %v", tc.name, d.Managed(), !tc.want.IsDefault)
+		}
+
+		// Re-encoding preserves every TLV, including unknown ones.
+		enc, err := d.Marshal()
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		again, err := DiscoveryDecode(nil, enc)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		again.PktSize, again.RawTLVs = d.PktSize, nil
+		if !reflect.DeepEqual(again, d) {
+			t.Errorf("%s: re-encoded packet decoded to %+v", tc.name, again)
+		}
+	}
+}
+
+func TestDiscoveryDecodeRejectsBadTLVs(t *testing.T) {
+	for _, raw := range []string{
+		"0206000a 01000401020304 17",  // short MAC, truncated TLV
+		"02060009 020006010203040506", // IPInfo without an address
+		"02060006 1c0003000016",       // 3 byte port
+		"02070000",                    // unknown command
+	} {
+		b, _ := hex.DecodeString(strings.Join(strings.Fields(raw), ""))
+		if _, err := DiscoveryDecode(nil, b); err == nil {
+			t.Errorf("Expected error decoding %s", raw)
+		}
+	}
+}
+
+func TestDiscoveryDecodeProbeReply(t *testing.T) {
+	var w TLVWriter
+	w.Write(MAC, []byte{1, 2, 3, 4, 5, 6})
+	w.Write(Platform, []byte("U7PG2"))
+	tlvs, err := w.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw := append([]byte{1, 0, 0, byte(len(tlvs))}, tlvs...)
+
+	d, err := DiscoveryDecode(nil, raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.MAC != [6]byte{1, 2, 3, 4, 5, 6} || d.Platform != "U7PG2" {
+		t.Errorf("Unexpected discovery %+v", d)
+	}
+
+	if _, err := DiscoveryDecode(nil, ProbeRequest); err != ErrDiscoveryProbe {
+		t.Errorf("Expected ErrDiscoveryProbe, got %v", err)
+	}
+}
+
+func TestTLVWriterRejectsLargePayload(t *testing.T) {
+	var w TLVWriter
+	w.Write(Hostname, make([]byte, 0x10000))
+	if _, err := w.Bytes(); err == nil {
+		t.Error("Expected error for oversized TLV")
+	}
+}
@@ -5,15 +5,18 @@ import (
 	"compress/zlib"
 	"crypto/aes"
 	"crypto/cipher"
+	"crypto/rand"
 	"encoding/binary"
 	"errors"
 	"io"
-	"io/ioutil"
 	"strconv"
 
 	"github.com/golang/snappy"
 )
 
+// DefaultKey is the well-known key used by devices in their factory-default state.
+var DefaultKey = []byte{0xba, 0x86, 0xf2, 0xbb, 0xe1, 0x07, 0xc7, 0xc5, 0x7e, 0xb5, 0xf2, 0x69, 0x07, 0x75, 0xc7, 0x12}
+
 // Inform captures the information contained in an inform packet.
 // Actual data stored in the payload is represented as JSON and can be
 // decoded with other methods in this package.
@@ -28,64 +31,119 @@ type Inform struct {
 	RawFlags    uint16
 
 	Encrypted, CompressedSnappy, CompressedZib bool
+	// EncryptedGCM is set if the payload is encrypted with AES-GCM rather than AES-CBC. The header
+	// of the packet is authenticated as additional data.
+	EncryptedGCM bool
+
+	iv    [16]byte
+	bufs  [6]*[]byte // Pooled buffers, see Release.
+	nbufs int
+}
+
+// Inform flags
+const (
+	FlagEncrypted        = 0x01
+	FlagCompressedZlib   = 0x02
+	FlagCompressedSnappy = 0x04
+	FlagEncryptedGCM     = 0x08
+)
+
+// gcmTagSize is the length of the authentication tag appended to AES-GCM payloads.
+const gcmTagSize = 16
+
+// Clone returns a deep copy of the packet.
+func (i *Inform) Clone() *Inform {
+	c := *i
+	c.IV = append([]byte(nil), i.IV...)
+	c.Data = append([]byte(nil), i.Data...)
+	c.bufs, c.nbufs = [6]*[]byte{}, 0
+	return &c
 }
 
-// CloneForReply duplicates the struct into a new structure to be modified and transmitted.
-func (i *Inform) CloneForReply() *Inform {
-	r := &Inform{
+// NewReply creates a packet to be sent in reply to this one, with a fresh random IV. The encryption
+// and compression of the reply match what the AP sent, as given by RawFlags. Data must be set by the caller.
+func (i *Inform) NewReply() (*Inform, error) {
+	iv := make([]byte, aes.BlockSize)
+	if _, err := rand.Read(iv); err != nil {
+		return nil, err
+	}
+	return &Inform{
 		Version:          i.Version,
 		APMAC:            i.APMAC,
+		IV:               iv,
 		DataVersion:      i.DataVersion,
-		DataLength:       i.DataLength,
-		Encrypted:        i.Encrypted,
-		CompressedSnappy: i.CompressedSnappy,
-		CompressedZib:    i.CompressedZib,
-		RawFlags:         i.RawFlags,
-	}
-	r.IV = make([]byte, len(i.IV))
-	copy(r.IV, i.IV)
-	r.Data = make([]byte, len(i.Data))
-	copy(r.Data, i.Data)
-	return r
+		Encrypted:        true,
+		CompressedSnappy: (i.RawFlags & FlagCompressedSnappy) > 0,
+		CompressedZib:    (i.RawFlags & FlagCompressedZlib) > 0,
+		EncryptedGCM:     (i.RawFlags & FlagEncryptedGCM) > 0,
+	}, nil
 }
 
 // Marshal creates a 'on-the-wire' bitstream representing the contents of the Inform packet.
-// NOTE: All flags and DataLength is ignored.
+// The payload is compressed if CompressedZib or CompressedSnappy is set, and encrypted with
+// AES-GCM if EncryptedGCM is set, otherwise AES-CBC.
+// NOTE: RawFlags and DataLength are ignored.
 func (i *Inform) Marshal(key []byte) ([]byte, error) {
-	var buff bytes.Buffer
-	payload, err := encrypt(i.Data, key, i.IV)
+	data, flags, err := i.compress()
 	if err != nil {
 		return nil, err
 	}
 
-	buff.Grow(len(payload) + 40)
-	buff.WriteString("TNBU")
-	err = binary.Write(&buff, binary.BigEndian, i.Version)
-	if err != nil {
-		return nil, err
+	if i.EncryptedGCM {
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		gcm, err := cipher.NewGCMWithNonceSize(block, len(i.IV))
+		if err != nil {
+			return nil, err
+		}
+		header := i.header(flags|FlagEncrypted|FlagEncryptedGCM, uint32(len(data)+gcmTagSize))
+		return gcm.Seal(header, i.IV, data, header), nil
 	}
 
-	buff.Write(i.APMAC[:])
-
-	err = binary.Write(&buff, binary.BigEndian, uint16(1)) //encryption only
+	payload, err := encrypt(data, key, i.IV)
 	if err != nil {
 		return nil, err
 	}
+	return append(i.header(flags|FlagEncrypted, uint32(len(payload))), payload...), nil
+}
 
-	buff.Write(i.IV[:])
-
-	err = binary.Write(&buff, binary.BigEndian, i.DataVersion)
-	if err != nil {
-		return nil, err
+// compress returns i.Data compressed as specified by CompressedZib and CompressedSnappy,
+// along with the corresponding flags. This is the reverse of the decompression in Payload().
+func (i *Inform) compress() ([]byte, uint16, error) {
+	var flags uint16
+	data := i.Data
+	if i.CompressedZib {
+		var b bytes.Buffer
+		w := zlib.NewWriter(&b)
+		if _, err := w.Write(data); err != nil {
+			return nil, 0, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, 0, err
+		}
+		data = b.Bytes()
+		flags |= FlagCompressedZlib
 	}
-	err = binary.Write(&buff, binary.BigEndian, uint32(len(payload)))
-	if err != nil {
-		return nil, err
+	if i.CompressedSnappy {
+		data = snappy.Encode(nil, data)
+		flags |= FlagCompressedSnappy
 	}
+	return data, flags, nil
+}
 
-	buff.Write(payload)
-
-	return buff.Bytes(), nil
+// header returns the 40-byte header of the packet, with the given flags and payload length.
+func (i *Inform) header(flags uint16, length uint32) []byte {
+	h := make([]byte, 40)
+	copy(h, "TNBU")
+	binary.BigEndian.PutUint32(h[4:], i.Version)
+	copy(h[8:], i.APMAC[:])
+	binary.BigEndian.PutUint16(h[14:], flags)
+	copy(h[16:32], i.IV)
+	binary.BigEndian.PutUint32(h[32:], i.DataVersion)
+	binary.BigEndian.PutUint32(h[36:], length)
+	return h
 }
 
 // performs PKCS7 padding and AES encryption on the given data.
@@ -105,122 +163,109 @@ func encrypt(data, key, iv []byte) ([]byte, error) {
 }
 
 // Payload decrypts and uncompresses using the given key, returning the raw payload.
+// The packet is not modified, so Payload can be retried with another key if decryption fails.
 func (i *Inform) Payload(key []byte) ([]byte, error) {
-	if i.Encrypted {
-		err := i.decrypt(key)
-		if err != nil {
+	d := i.Data
+	var err error
+	if i.Encrypted || i.EncryptedGCM {
+		if d, err = i.decrypt(key); err != nil {
 			return nil, err
 		}
 	}
 	if i.CompressedSnappy {
-		err := i.decompressSnappy()
-		if err != nil {
+		if d, err = i.decompressSnappy(d); err != nil {
 			return nil, err
 		}
 	}
 	if i.CompressedZib {
-		err := i.decompressZlib()
-		if err != nil {
+		if d, err = i.inflate(d); err != nil {
 			return nil, err
 		}
 	}
-	//fmt.Println(string(i.Data))
-	return i.Data, nil
+	//fmt.Println(string(d))
+	return d, nil
 }
 
-// Called internally to reverse Zlib compression on i.Data.
-func (i *Inform) decompressZlib() error {
-	b := bytes.NewReader(i.Data)
-
-	r, err := zlib.NewReader(b)
+// Called internally to reverse Snappy compression on data.
+func (i *Inform) decompressSnappy(data []byte) ([]byte, error) {
+	n, err := snappy.DecodedLen(data)
 	if err != nil {
-		return err
+		return nil, err
 	}
-
-	i.Data, err = ioutil.ReadAll(r)
-	if err != nil {
-		return err
+	if n > MaxPayloadSize {
+		return nil, ErrPayloadTooLarge
 	}
-	i.CompressedZib = false
-	return nil
+	return snappy.Decode(*i.getBuf(n), data)
 }
 
-// Called internally to reverse Snappy compression on i.Data.
-func (i *Inform) decompressSnappy() error {
-	var err error
-	i.Data, err = snappy.Decode(i.Data, i.Data)
-	i.CompressedSnappy = false
-	return err
-}
-
-func (i *Inform) decrypt(key []byte) error {
+// decrypt decrypts i.Data into a pooled buffer, leaving i.Data intact.
+func (i *Inform) decrypt(key []byte) ([]byte, error) {
 	block, err := aes.NewCipher(key)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	if i.EncryptedGCM {
+		return i.decryptGCM(block)
 	}
 
+	if len(i.Data)%aes.BlockSize != 0 {
+		return nil, errors.New("payload is not a multiple of the block size")
+	}
+	d := *i.getBuf(len(i.Data))
 	mode := cipher.NewCBCDecrypter(block, i.IV)
-	mode.CryptBlocks(i.Data, i.Data)
-	d, err := pkcs7Unpad(i.Data, aes.BlockSize)
+	mode.CryptBlocks(d, i.Data)
+	return pkcs7Unpad(d, aes.BlockSize)
+}
+
+// decryptGCM authenticates and decrypts i.Data, which is encrypted with AES-GCM.
+func (i *Inform) decryptGCM(block cipher.Block) ([]byte, error) {
+	gcm, err := cipher.NewGCMWithNonceSize(block, len(i.IV))
 	if err != nil {
-		return err
+		return nil, err
 	}
-	i.Data = d
-	i.Encrypted = false
-	return nil
+	return gcm.Open((*i.getBuf(len(i.Data)))[:0], i.IV, i.Data, i.header(i.RawFlags, i.DataLength))
 }
 
 // InformDecode decodes an inform packet.
-// use .Payload() to extract the contents of the packet.
+// use .Payload() to extract the contents of the packet, and .Release() once done with it.
 func InformDecode(r io.Reader) (*Inform, error) {
 	pkt := &Inform{}
 
-	magic := make([]byte, 4)
-	n, err := io.ReadFull(r, magic)
-	if n != 4 || err != nil || string(magic) != "TNBU" {
-		return nil, errors.New("Could not read magic header, got " + string(magic))
+	var header [40]byte
+	if _, err := io.ReadFull(r, header[:4]); err != nil || string(header[:4]) != "TNBU" {
+		return nil, errors.New("Could not read magic header, got " + string(header[:4]))
 	}
-
-	if pktVersionReadErr := binary.Read(r, binary.BigEndian, &pkt.Version); pktVersionReadErr != nil {
-		return nil, pktVersionReadErr
+	if _, err := io.ReadFull(r, header[4:]); err != nil {
+		return nil, err
 	}
+
+	pkt.Version = binary.BigEndian.Uint32(header[4:])
 	if pkt.Version != 0 {
 		return nil, errors.New("Unsupported protocol version: " + strconv.Itoa(int(pkt.Version)))
 	}
+	copy(pkt.APMAC[:], header[8:14])
 
-	mac := make([]byte, 6)
-	_, err = io.ReadFull(r, mac)
-	if err != nil {
-		return nil, err
-	}
-	copy(pkt.APMAC[:], mac)
+	pkt.RawFlags = binary.BigEndian.Uint16(header[14:])
+	pkt.Encrypted = (pkt.RawFlags & FlagEncrypted) > 0
+	pkt.CompressedZib = (pkt.RawFlags & FlagCompressedZlib) > 0
+	pkt.CompressedSnappy = (pkt.RawFlags & FlagCompressedSnappy) > 0
+	pkt.EncryptedGCM = (pkt.RawFlags & FlagEncryptedGCM) > 0
 
-	if pktFlagsReadErr := binary.Read(r, binary.BigEndian, &pkt.RawFlags); pktFlagsReadErr != nil {
-		return nil, pktFlagsReadErr
-	}
-	pkt.Encrypted = (pkt.RawFlags & 0x01) > 0
-	pkt.CompressedZib = (pkt.RawFlags & 0x02) > 0
-	pkt.CompressedSnappy = (pkt.RawFlags & 0x04) > 0
+	copy(pkt.iv[:], header[16:32])
+	pkt.IV = pkt.iv[:]
 
-	pkt.IV = make([]byte, 16)
-	_, err = io.ReadFull(r, pkt.IV)
-	if err != nil {
-		return nil, err
-	}
-
-	if pktDataVersionReadErr := binary.Read(r, binary.BigEndian, &pkt.DataVersion); pktDataVersionReadErr != nil {
-		return nil, pktDataVersionReadErr
-	}
+	pkt.DataVersion = binary.BigEndian.Uint32(header[32:])
 	if pkt.DataVersion != 1 {
 		return nil, errors.New("Unsupported data version: " + strconv.Itoa(int(pkt.DataVersion)))
 	}
 
-	if pktDataLengthReadErr := binary.Read(r, binary.BigEndian, &pkt.DataLength); pktDataLengthReadErr != nil {
-		return nil, pktDataLengthReadErr
+	pkt.DataLength = binary.BigEndian.Uint32(header[36:])
+	if uint64(pkt.DataLength) > uint64(MaxPayloadSize) {
+		return nil, ErrPayloadTooLarge
 	}
-	pkt.Data = make([]byte, pkt.DataLength)
-	_, err = io.ReadFull(r, pkt.Data)
-	if err != nil {
+	pkt.Data = *pkt.getBuf(int(pkt.DataLength))
+	if _, err := io.ReadFull(r, pkt.Data); err != nil {
+		pkt.Release()
 		return nil, err
 	}
 
@@ -0,0 +1,319 @@
+package packet
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"testing"
+)
+
+var testKey = []byte{0x41, 0xd6, 0x52, 0x9f, 0xd5, 0x55, 0xfb, 0xb1, 0xbd, 0xee, 0xaf, 0xeb, 0x99, 0x55, 0x10, 0xfa}
+
+func testInform(gcm bool) *Inform {
+	return &Inform{
+		APMAC:        [6]byte{0xf0, 0x9f, 0xc2, 0xaa, 0xbb, 0xcc},
+		IV:           []byte("0123456789abcdef"),
+		DataVersion:  1,
+		Data:         []byte(`{"_type":"noop","interval":3}`),
+		EncryptedGCM: gcm,
+	}
+}
+
+// gcmInform constructs an AES-GCM inform the way firmware does, independently of Marshal.
+func gcmInform(t *testing.T, mac [6]byte, iv, payload []byte) []byte {
+	header := make([]byte, 40)
+	copy(header, "TNBU")
+	copy(header[8:], mac[:])
+	binary.BigEndian.PutUint16(header[14:], FlagEncrypted|FlagEncryptedGCM)
+	copy(header[16:], iv)
+	binary.BigEndian.PutUint32(header[32:], 1)
+	binary.BigEndian.PutUint32(header[36:], uint32(len(payload)+16))
+
+	block, err := aes.NewCipher(testKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gcm, err := cipher.NewGCMWithNonceSize(block, 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return gcm.Seal(header, iv, payload, header)
+}
+
+func TestInformDecodeGCM(t *testing.T) {
+	mac := [6]byte{0xf0, 0x9f, 0xc2, 0xaa, 0xbb, 0xcc}
+	payload := []byte(`{"mac":"f0:9f:c2:aa:bb:cc","cfgversion":"abc"}`)
+	pkt, err := InformDecode(bytes.NewReader(gcmInform(t, mac, []byte("fedcba9876543210"), payload)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !pkt.EncryptedGCM || pkt.APMAC != mac {
+		t.Errorf("Unexpected packet %+v", pkt)
+	}
+	d, err := pkt.Payload(testKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(d, payload) {
+		t.Errorf("Expected %q, got %q", payload, d)
+	}
+}
+
+func TestInformGCMAuthenticatesHeader(t *testing.T) {
+	raw := gcmInform(t, [6]byte{0xf0, 0x9f, 0xc2, 0xaa, 0xbb, 0xcc}, []byte("fedcba9876543210"), []byte(`{}`))
+	raw[13] ^= 0x01 // Modify the MAC address, which is not encrypted.
+	pkt, err := InformDecode(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := pkt.Payload(testKey); err == nil {
+		t.Error("Expected error decrypting packet with modified header")
+	}
+}
+
+func TestInformMarshalRoundTrip(t *testing.T) {
+	for _, gcm := range []bool{false, true} {
+		in := testInform(gcm)
+		raw, err := in.Marshal(testKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out, err := InformDecode(bytes.NewReader(raw))
+		if err != nil {
+			t.Fatalf("gcm=%v: %v", gcm, err)
+		}
+		if out.EncryptedGCM != gcm || !out.Encrypted {
+			t.Errorf("gcm=%v: unexpected flags %x", gcm, out.RawFlags)
+		}
+		d, err := out.Payload(testKey)
+		if err != nil {
+			t.Fatalf("gcm=%v: %v", gcm, err)
+		}
+		if !bytes.Equal(d, testInform(gcm).Data) {
+			t.Errorf("gcm=%v: expected %q, got %q", gcm, testInform(gcm).Data, d)
+		}
+
+		// A failed decryption leaves the packet intact, so it can be retried with another key.
+		wrongKey := append([]byte{}, testKey...)
+		wrongKey[0] ^= 0xff
+		pkt, _ := InformDecode(bytes.NewReader(raw))
+		if d, err := pkt.Payload(wrongKey); gcm && err == nil {
+			t.Error("Expected error decrypting with the wrong key")
+		} else if err == nil && bytes.Equal(d, testInform(gcm).Data) {
+			t.Errorf("gcm=%v: decrypted with the wrong key", gcm)
+		}
+		if d, err := pkt.Payload(testKey); err != nil || !bytes.Equal(d, testInform(gcm).Data) {
+			t.Errorf("gcm=%v: retry with the right key failed: %q, %v", gcm, d, err)
+		}
+	}
+}
+
+func TestInformMarshalCompressed(t *testing.T) {
+	data := bytes.Repeat([]byte(`{"system_cfg":"wireless.1.ssid=gofi\n"}`), 64)
+	for _, tc := range []struct {
+		gcm, zlib, snappy bool
+		flags             uint16
+	}{
+		{zlib: true, flags: FlagEncrypted | FlagCompressedZlib},
+		{snappy: true, flags: FlagEncrypted | FlagCompressedSnappy},
+		{gcm: true, snappy: true, flags: FlagEncrypted | FlagEncryptedGCM | FlagCompressedSnappy},
+		{gcm: true, zlib: true, snappy: true, flags: FlagEncrypted | FlagEncryptedGCM | FlagCompressedZlib | FlagCompressedSnappy},
+	} {
+		in := testInform(tc.gcm)
+		in.Data = data
+		in.CompressedZib, in.CompressedSnappy = tc.zlib, tc.snappy
+		raw, err := in.Marshal(testKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(raw) >= len(data) {
+			t.Errorf("%+v: expected payload to be compressed, got %d bytes", tc, len(raw))
+		}
+
+		out, err := InformDecode(bytes.NewReader(raw))
+		if err != nil {
+			t.Fatalf("%+v: %v", tc, err)
+		}
+		if out.RawFlags != tc.flags {
+			t.Errorf("%+v: expected flags %x, got %x", tc, tc.flags, out.RawFlags)
+		}
+		d, err := out.Payload(testKey)
+		if err != nil {
+			t.Fatalf("%+v: %v", tc, err)
+		}
+		if !bytes.Equal(d, data) {
+			t.Errorf("%+v: payload did not round-trip", tc)
+		}
+	}
+}
+
+func TestNewReplyNegotiatesFlags(t *testing.T) {
+	in := testInform(true)
+	in.CompressedSnappy = true
+	raw, err := in.Marshal(testKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkt, err := InformDecode(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := pkt.Payload(testKey); err != nil {
+		t.Fatal(err)
+	}
+
+	// The reply is usually cloned after the inform has been decrypted & decompressed.
+	reply, err := pkt.NewReply()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reply.EncryptedGCM || !reply.CompressedSnappy || reply.CompressedZib {
+		t.Errorf("Expected reply to use AES-GCM and snappy, got %+v", reply)
+	}
+}
+
+func TestNewReplyUsesFreshIV(t *testing.T) {
+	pkt, err := InformDecode(bytes.NewReader(gcmInform(t, [6]byte{1, 2, 3, 4, 5, 6}, []byte("fedcba9876543210"), []byte(`{}`))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	seen := map[string]bool{string(pkt.IV): true}
+	for n := 0; n < 32; n++ {
+		reply, err := pkt.NewReply()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(reply.IV) != 16 {
+			t.Fatalf("Expected 16 byte IV, got %d bytes", len(reply.IV))
+		}
+		if seen[string(reply.IV)] {
+			t.Fatalf("IV %x reused", reply.IV)
+		}
+		seen[string(reply.IV)] = true
+		if len(reply.Data) != 0 {
+			t.Error("Expected reply to not contain the inform payload")
+		}
+	}
+}
+
+func TestCloneIsDeep(t *testing.T) {
+	pkt := testInform(false)
+	c := pkt.Clone()
+	c.IV[0] ^= 0xff
+	c.Data[0] ^= 0xff
+	if pkt.IV[0] == c.IV[0] || pkt.Data[0] == c.Data[0] {
+		t.Error("Expected clone to not share buffers")
+	}
+}
+
+func TestInformDecodeRejectsOversizedPayload(t *testing.T) {
+	header := testInform(false).header(FlagEncrypted, 0xffffffff)
+	if _, err := InformDecode(bytes.NewReader(header)); err != ErrPayloadTooLarge {
+		t.Errorf("Expected ErrPayloadTooLarge, got %v", err)
+	}
+
+	// Payloads which decompress past the limit are also rejected.
+	defer func(max int) { MaxPayloadSize = max }(MaxPayloadSize)
+	MaxPayloadSize = 4096
+	for _, snappy := range []bool{false, true} {
+		in := testInform(false)
+		in.Data = make([]byte, 2*MaxPayloadSize)
+		in.CompressedZib, in.CompressedSnappy = !snappy, snappy
+		raw, err := in.Marshal(testKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pkt, err := InformDecode(bytes.NewReader(raw))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := pkt.Payload(testKey); err != ErrPayloadTooLarge {
+			t.Errorf("snappy=%v: expected ErrPayloadTooLarge, got %v", snappy, err)
+		}
+		pkt.Release()
+	}
+}
+
+func TestInformDecodeRejectsTruncatedBlock(t *testing.T) {
+	raw, err := testInform(false).Marshal(testKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw = raw[:len(raw)-1]
+	binary.BigEndian.PutUint32(raw[36:], uint32(len(raw)-40))
+	pkt, err := InformDecode(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := pkt.Payload(testKey); err == nil {
+		t.Error("Expected error for payload which is not a multiple of the block size")
+	}
+}
+
+// decodeInform performs the steps the server takes to decode an inform.
+func decodeInform(raw []byte) error {
+	pkt, err := InformDecode(bytes.NewReader(raw))
+	if err != nil {
+		return err
+	}
+	defer pkt.Release()
+	_, err = pkt.Payload(testKey)
+	return err
+}
+
+func marshalTestInform(tb testing.TB, gcm, zlib, snappy bool) []byte {
+	in := testInform(gcm)
+	in.Data = bytes.Repeat([]byte(`{"mac":"f0:9f:c2:aa:bb:cc","model":"U7LR","uptime":1234},`), 200)
+	in.CompressedZib, in.CompressedSnappy = zlib, snappy
+	raw, err := in.Marshal(testKey)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return raw
+}
+
+func TestInformDecodeAllocs(t *testing.T) {
+	if raceEnabled {
+		t.Skip("buffers are not reliably pooled with the race detector enabled")
+	}
+	for _, tc := range []struct {
+		name              string
+		gcm, zlib, snappy bool
+		max               float64
+	}{
+		{name: "cbc", max: 7},
+		{name: "gcm", gcm: true, max: 8},
+		{name: "cbc+snappy", snappy: true, max: 7},
+		{name: "cbc+zlib", zlib: true, max: 9},
+	} {
+		raw := marshalTestInform(t, tc.gcm, tc.zlib, tc.snappy)
+		if err := decodeInform(raw); err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		// Allocations must not grow with the size of the payload once the pools are warm.
+		allocs := testing.AllocsPerRun(100, func() {
+			decodeInform(raw)
+		})
+		if allocs > tc.max {
+			t.Errorf("%s: %v allocations per inform, expected at most %v", tc.name, allocs, tc.max)
+		}
+	}
+}
+
+func benchmarkInformDecode(b *testing.B, gcm, zlib, snappy bool) {
+	raw := marshalTestInform(b, gcm, zlib, snappy)
+	b.ReportAllocs()
+	b.SetBytes(int64(len(raw)))
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		if err := decodeInform(raw); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkInformDecodeCBC(b *testing.B)       { benchmarkInformDecode(b, false, false, false) }
+func BenchmarkInformDecodeGCM(b *testing.B)       { benchmarkInformDecode(b, true, false, false) }
+func BenchmarkInformDecodeCBCSnappy(b *testing.B) { benchmarkInformDecode(b, false, false, true) }
+func BenchmarkInformDecodeCBCZlib(b *testing.B)   { benchmarkInformDecode(b, false, true, false) }
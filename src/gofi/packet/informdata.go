@@ -1,10 +1,8 @@
 package packet
 
 import (
-	"encoding/hex"
 	"encoding/json"
 	"fmt"
-	"log"
 	"strconv"
 	"time"
 )
@@ -17,6 +15,7 @@ type InformData struct {
 	Model           string `json:"model,omitempty"`
 	ModelName       string `json:"model_display,omitempty"`
 	InformURL       string `json:"inform_url,omitempty"`
+	FirmwareVersion string `json:"version,omitempty"`
 	IsDefaultConfig bool   `json:"default,omitempty"`
 	State           int    `json:"state,omitempty"`
 
@@ -186,6 +185,20 @@ type CommandData struct {
 	ManagementConfig string `json:"mgmt_cfg,omitempty"`
 	SystemConfig     string `json:"system_cfg,omitempty"`
 	BlockedStations  string `json:"blocked_sta"`
+
+	// Firmware upgrades.
+	URL     string `json:"url,omitempty"`
+	MD5Sum  string `json:"md5sum,omitempty"`
+	Version string `json:"version,omitempty"`
+
+	// Guest authorization. Rates are in Kbps, the quota in MB.
+	Minutes  int `json:"minutes,omitempty"`
+	UpKbps   int `json:"up,omitempty"`
+	DownKbps int `json:"down,omitempty"`
+	QuotaMB  int `json:"bytes,omitempty"`
+
+	Radio string `json:"radio,omitempty"`    // Radio to scan (ie: ng or na), all if empty.
+	Port  int    `json:"port_idx,omitempty"` // Switch port, numbered from 1.
 }
 
 // MakeNoop creates the payload section of a noop response.
@@ -195,29 +208,18 @@ func MakeNoop(pollDelay int) ([]byte, error) {
 
 // MakeLocate creates the payload section of a locate response.
 func MakeLocate() ([]byte, error) {
-	return json.Marshal(CommandData{
-		Type:            "cmd",
-		Cmd:             "locate",
-		ServerTimestamp: unixMicroPSTString(),
-		DatetimeRFC3339: time.Now().Format(time.RFC3339),
-		TimeStr:         fmt.Sprint(time.Now().Unix()),
-	})
+	return json.Marshal(command("locate"))
+}
+
+// MakeSetDefault creates the payload section of a set-default command, which resets the AP to
+// its factory-default configuration.
+func MakeSetDefault() ([]byte, error) {
+	return json.Marshal(command("set-default"))
 }
 
 // MakeKickStation creates the payload section of a kick-sta command.
 func MakeKickStation(mac [6]byte) ([]byte, error) {
-	macStr := hex.EncodeToString([]byte{mac[0]}) + ":" + hex.EncodeToString([]byte{mac[1]}) + ":" + hex.EncodeToString([]byte{mac[2]}) + ":" +
-		hex.EncodeToString([]byte{mac[3]}) + ":" + hex.EncodeToString([]byte{mac[4]}) + ":" + hex.EncodeToString([]byte{mac[5]})
-	log.Printf("Mac: %s", macStr)
-
-	return json.Marshal(CommandData{
-		Type:            "cmd",
-		Cmd:             "kick-sta",
-		MacAddr:         macStr,
-		ServerTimestamp: unixMicroPSTString(),
-		DatetimeRFC3339: time.Now().Format(time.RFC3339),
-		TimeStr:         fmt.Sprint(time.Now().Unix()),
-	})
+	return json.Marshal(stationCommand("kick-sta", mac))
 }
 
 // MakeConfigUpdate creates the payload section of a response which sets all configuration.
@@ -246,7 +248,7 @@ func InformCfgVersion(d []byte) string {
 
 //Credit: mcrute - https://github.com/mcrute/go-inform/blob/master/inform/tx_messages.go
 func unixMicroPST() int64 {
-	tnano := time.Now().UnixNano()
+	tnano := now().UnixNano()
 	return tnano / int64(time.Millisecond)
 }
 
@@ -0,0 +1,95 @@
+package packet
+
+import (
+	"bytes"
+	"compress/zlib"
+	"errors"
+	"io"
+	"sync"
+)
+
+// MaxPayloadSize is the largest inform payload accepted, before and after decompression.
+var MaxPayloadSize = 1 << 20
+
+// ErrPayloadTooLarge is returned if an inform payload exceeds MaxPayloadSize.
+var ErrPayloadTooLarge = errors.New("inform payload exceeds maximum size")
+
+// bufPool holds buffers for reading, decrypting and decompressing informs.
+// Pointers are pooled to avoid allocating when the slice header is put back.
+var bufPool = sync.Pool{
+	New: func() interface{} { return new([]byte) },
+}
+
+// zlibPool holds zlib readers, which are expensive to allocate.
+var zlibPool sync.Pool
+
+// getBuf returns a pooled buffer of length n, recording it in the packet so it is returned by Release.
+func (i *Inform) getBuf(n int) *[]byte {
+	bp := bufPool.Get().(*[]byte)
+	if cap(*bp) < n {
+		*bp = make([]byte, n)
+	}
+	*bp = (*bp)[:n]
+	if i.nbufs < len(i.bufs) {
+		i.bufs[i.nbufs] = bp
+		i.nbufs++
+	}
+	return bp
+}
+
+// Release returns the buffers used by the packet to a pool, so they can be reused to decode
+// other packets. Data, and any slice returned by Payload, must not be used after calling Release.
+func (i *Inform) Release() {
+	for n := 0; n < i.nbufs; n++ {
+		bufPool.Put(i.bufs[n])
+		i.bufs[n] = nil
+	}
+	i.nbufs = 0
+	i.Data = nil
+}
+
+// inflate decompresses zlib-compressed data into a pooled buffer.
+func (i *Inform) inflate(data []byte) ([]byte, error) {
+	src := bytes.NewReader(data)
+	var r io.ReadCloser
+	if pooled, ok := zlibPool.Get().(io.ReadCloser); ok {
+		if err := pooled.(zlib.Resetter).Reset(src, nil); err != nil {
+			return nil, err
+		}
+		r = pooled
+	} else {
+		var err error
+		if r, err = zlib.NewReader(src); err != nil {
+			return nil, err
+		}
+	}
+	defer zlibPool.Put(r)
+
+	size := 4 * len(data)
+	if size > MaxPayloadSize {
+		size = MaxPayloadSize
+	}
+	bp := i.getBuf(size)
+	buf := (*bp)[:0]
+	defer func() { *bp = buf[:0] }() // Keep the buffer if it grew.
+	for {
+		if len(buf) == cap(buf) {
+			if len(buf) > MaxPayloadSize {
+				return nil, ErrPayloadTooLarge
+			}
+			buf = append(buf, 0)[:len(buf)]
+		}
+		n, err := r.Read(buf[len(buf):cap(buf)])
+		buf = buf[:len(buf)+n]
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(buf) > MaxPayloadSize {
+		return nil, ErrPayloadTooLarge
+	}
+	return buf, nil
+}
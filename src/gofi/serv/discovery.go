@@ -0,0 +1,169 @@
+package serv
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+// DiscoveryGroup is the multicast group devices announce themselves to.
+var DiscoveryGroup = &net.UDPAddr{IP: net.IPv4(233, 89, 188, 1), Port: 10001}
+
+// duplicateWindow is how long a discovery packet is remembered, so copies received on
+// other sockets (or via both broadcast and multicast) are dropped.
+const duplicateWindow = 2 * time.Second
+
+// discoveryListener holds the sockets discovery packets are received on.
+type discoveryListener struct {
+	conns      []*net.UDPConn
+	ifaces     []string // Interfaces being listened on.
+	restricted bool     // True if only packets from ifaces should be accepted.
+
+	seenLock sync.Mutex
+	seen     map[string]time.Time
+}
+
+// listenDiscovery joins the discovery multicast group on each of the named interfaces, or all
+// multicast-capable interfaces if none are given. Sockets are bound to the wildcard address,
+// so they also receive broadcast announcements and replies to probes.
+func listenDiscovery(ifaceNames []string) (*discoveryListener, error) {
+	out := &discoveryListener{
+		restricted: len(ifaceNames) > 0,
+		seen:       map[string]time.Time{},
+	}
+
+	var ifaces []net.Interface
+	if out.restricted {
+		for _, name := range ifaceNames {
+			iface, err := net.InterfaceByName(name)
+			if err != nil {
+				return nil, fmt.Errorf("discovery interface %q: %v", name, err)
+			}
+			ifaces = append(ifaces, *iface)
+		}
+	} else {
+		all, err := net.Interfaces()
+		if err != nil {
+			return nil, err
+		}
+		for _, iface := range all {
+			if iface.Flags&net.FlagUp != 0 && iface.Flags&net.FlagMulticast != 0 && iface.Flags&net.FlagLoopback == 0 {
+				ifaces = append(ifaces, iface)
+			}
+		}
+	}
+
+	for i := range ifaces {
+		conn, err := net.ListenMulticastUDP("udp4", &ifaces[i], DiscoveryGroup)
+		if err != nil {
+			if out.restricted {
+				out.close()
+				return nil, fmt.Errorf("joining discovery group on %s: %v", ifaces[i].Name, err)
+			}
+			log.Printf("Not listening for discovery on %s: %s\n", ifaces[i].Name, err)
+			continue
+		}
+		out.conns = append(out.conns, conn)
+		out.ifaces = append(out.ifaces, ifaces[i].Name)
+	}
+
+	// Fall back to broadcast only if there are no usable multicast interfaces.
+	if len(out.conns) == 0 {
+		conn, err := net.ListenUDP("udp", &net.UDPAddr{Port: DiscoveryGroup.Port})
+		if err != nil {
+			return nil, err
+		}
+		out.conns = append(out.conns, conn)
+	}
+
+	for _, conn := range out.conns {
+		if err := enablePacketInfo(conn); err != nil {
+			log.Printf("Guessing the interface of discovery packets from their source address: %s\n", err)
+		}
+	}
+	return out, nil
+}
+
+func (l *discoveryListener) close() error {
+	var err error
+	for _, conn := range l.conns {
+		if e := conn.Close(); e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// duplicate returns true if the same packet was received from the same address recently.
+func (l *discoveryListener) duplicate(addr *net.UDPAddr, pkt []byte) bool {
+	key := addr.String() + "|" + string(pkt)
+	now := time.Now()
+
+	l.seenLock.Lock()
+	defer l.seenLock.Unlock()
+	for k, t := range l.seen {
+		if now.Sub(t) > duplicateWindow {
+			delete(l.seen, k)
+		}
+	}
+	if _, ok := l.seen[key]; ok {
+		return true
+	}
+	l.seen[key] = now
+	return false
+}
+
+// ingressInterface returns the name of the interface a packet from ip was received on, or "" if
+// discovery is restricted to other interfaces. The interface is read from the control messages
+// received with the packet where the kernel reports it, otherwise it is guessed from ip.
+func (l *discoveryListener) ingressInterface(ip net.IP, oob []byte) string {
+	if index := packetInterfaceIndex(oob); index != 0 {
+		if iface, err := net.InterfaceByIndex(index); err == nil {
+			if !l.restricted {
+				return iface.Name
+			}
+			for _, name := range l.ifaces {
+				if name == iface.Name {
+					return name
+				}
+			}
+			return ""
+		}
+	}
+	return l.interfaceFor(ip)
+}
+
+// interfaceFor returns the name of the interface the given address is reachable on directly.
+func (l *discoveryListener) interfaceFor(ip net.IP) string {
+	subnets := map[string][]*net.IPNet{}
+	for _, name := range l.ifaces {
+		iface, err := net.InterfaceByName(name)
+		if err != nil {
+			continue
+		}
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, a := range addrs {
+			if subnet, ok := a.(*net.IPNet); ok {
+				subnets[name] = append(subnets[name], subnet)
+			}
+		}
+	}
+	return matchInterface(ip, l.ifaces, subnets)
+}
+
+// matchInterface returns the first interface (in the order given) with a subnet containing ip.
+func matchInterface(ip net.IP, ifaces []string, subnets map[string][]*net.IPNet) string {
+	for _, name := range ifaces {
+		for _, subnet := range subnets[name] {
+			if subnet.Contains(ip) {
+				return name
+			}
+		}
+	}
+	return ""
+}
@@ -0,0 +1,40 @@
+//go:build linux
+// +build linux
+
+package serv
+
+import (
+	"net"
+	"syscall"
+	"unsafe"
+)
+
+// enablePacketInfo asks the kernel to report the interface each packet on conn is received on.
+func enablePacketInfo(conn *net.UDPConn) error {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var sockErr error
+	if err := raw.Control(func(fd uintptr) {
+		sockErr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_PKTINFO, 1)
+	}); err != nil {
+		return err
+	}
+	return sockErr
+}
+
+// packetInterfaceIndex returns the index of the interface a packet was received on, from the
+// control messages read with it, or 0 if not known.
+func packetInterfaceIndex(oob []byte) int {
+	msgs, err := syscall.ParseSocketControlMessage(oob)
+	if err != nil {
+		return 0
+	}
+	for _, msg := range msgs {
+		if msg.Header.Level == syscall.IPPROTO_IP && msg.Header.Type == syscall.IP_PKTINFO && len(msg.Data) >= syscall.SizeofInet4Pktinfo {
+			return int((*syscall.Inet4Pktinfo)(unsafe.Pointer(&msg.Data[0])).Ifindex)
+		}
+	}
+	return 0
+}
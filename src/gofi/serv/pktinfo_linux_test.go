@@ -0,0 +1,41 @@
+package serv
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestIngressInterface(t *testing.T) {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if err := enablePacketInfo(conn); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.WriteToUDP([]byte("kek"), conn.LocalAddr().(*net.UDPAddr)); err != nil {
+		t.Fatal(err)
+	}
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	buf, oob := make([]byte, 16), make([]byte, 128)
+	_, oobn, _, addr, err := conn.ReadMsgUDP(buf, oob)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lo, err := net.InterfaceByIndex(packetInterfaceIndex(oob[:oobn]))
+	if err != nil || lo.Flags&net.FlagLoopback == 0 {
+		t.Fatalf("Expected packet to be received on loopback, got %+v (%v)", lo, err)
+	}
+	// The reported interface is used, even though no listened interface has a matching subnet.
+	l := &discoveryListener{}
+	if iface := l.ingressInterface(addr.IP, oob[:oobn]); iface != lo.Name {
+		t.Errorf("Expected %s, got %q", lo.Name, iface)
+	}
+	l = &discoveryListener{ifaces: []string{"kek0"}, restricted: true}
+	if iface := l.ingressInterface(addr.IP, oob[:oobn]); iface != "" {
+		t.Errorf("Expected packet from unlisted interface to be ignored, got %q", iface)
+	}
+}
@@ -0,0 +1,17 @@
+//go:build !linux
+// +build !linux
+
+package serv
+
+import "net"
+
+// enablePacketInfo does nothing, as the interface packets are received on is only reported on
+// Linux. It is guessed from the source address instead.
+func enablePacketInfo(conn *net.UDPConn) error {
+	return nil
+}
+
+// packetInterfaceIndex returns 0, as the interface is not known.
+func packetInterfaceIndex(oob []byte) int {
+	return 0
+}
@@ -10,6 +10,7 @@ import (
 	"net"
 	"net/http"
 	"strconv"
+	"sync"
 )
 
 type informHandler interface {
@@ -18,8 +19,7 @@ type informHandler interface {
 
 // Serv represents a running server
 type Serv struct {
-	listenAddr    *net.UDPAddr
-	serverConn    *net.UDPConn
+	discovery     *discoveryListener
 	informHandler informHandler
 
 	DiscoveryPackets chan *packet.Discovery
@@ -30,7 +30,8 @@ type Serv struct {
 }
 
 // New creates a new server bound to the Ubiquiti discovery port and the given port for the HTTP server.
-func New(ihandler informHandler, httpListener string) (*Serv, error) {
+// Discovery packets are received on the given interfaces, or all interfaces if none are given.
+func New(ihandler informHandler, httpListener string, discoveryIfaces []string) (*Serv, error) {
 	out := Serv{
 		close:            make(chan bool),
 		DiscoveryPackets: make(chan *packet.Discovery, 1),
@@ -38,21 +39,29 @@ func New(ihandler informHandler, httpListener string) (*Serv, error) {
 	}
 	var err error
 
-	out.listenAddr, err = net.ResolveUDPAddr("udp", ":10001")
+	out.discovery, err = listenDiscovery(discoveryIfaces)
 	if err != nil {
 		return nil, err
 	}
-
-	/* Now listen at selected port */
-	out.serverConn, err = net.ListenUDP("udp", out.listenAddr)
-	if err != nil {
-		return nil, err
+	if len(out.discovery.ifaces) > 0 {
+		log.Printf("Listening for discovery on %v\n", out.discovery.ifaces)
 	}
 
 	out.makeHTTPServer(httpListener)
 
 	go out.httpMainloop()
-	go out.discoveryMainloop()
+	var wg sync.WaitGroup
+	for _, conn := range out.discovery.conns {
+		wg.Add(1)
+		go func(conn *net.UDPConn) {
+			defer wg.Done()
+			out.discoveryMainloop(conn)
+		}(conn)
+	}
+	go func() {
+		wg.Wait()
+		close(out.DiscoveryPackets)
+	}()
 	return &out, nil
 }
 
@@ -63,11 +72,14 @@ func (s *Serv) makeHTTPServer(listener string) {
 		io.WriteString(w, "hello world\n")
 	})
 	http.HandleFunc("/inform", func(w http.ResponseWriter, r *http.Request) {
-		informPkt, err := packet.InformDecode(r.Body)
+		// The header is 40 bytes, followed by the payload.
+		body := http.MaxBytesReader(w, r.Body, int64(40+packet.MaxPayloadSize))
+		informPkt, err := packet.InformDecode(body)
 		if err != nil {
 			fmt.Println("Error decoding Inform: ", err)
 		} else {
 			data, err := s.informHandler.HandleInform(r.RemoteAddr, informPkt)
+			informPkt.Release()
 			if err != nil {
 				fmt.Printf("HandleInform() err: %s\n", err)
 				return
@@ -83,9 +95,9 @@ func (s *Serv) makeHTTPServer(listener string) {
 
 // Close shuts down the server
 func (s *Serv) Close() error {
-	socketErr := s.serverConn.Close()
-	httpErr := s.httpServ.Close()
 	close(s.close)
+	socketErr := s.discovery.close()
+	httpErr := s.httpServ.Close()
 	if socketErr != nil {
 		return socketErr
 	}
@@ -96,31 +108,116 @@ func (s *Serv) Close() error {
 func (s *Serv) Recieve() (*packet.Discovery, error) {
 	buf := make([]byte, 8192)
 
-	n, addr, err := s.serverConn.ReadFromUDP(buf)
+	n, addr, err := s.discovery.conns[0].ReadFromUDP(buf)
 	if err != nil {
 		return nil, err
 	}
 	return packet.DiscoveryDecode(addr, buf[:n])
 }
 
+// Probe broadcasts a discovery request to each target, which is either the name of a network
+// interface or a subnet in CIDR notation (ie: 10.1.2.0/24). If no targets are given, the request
+// is broadcast on the interfaces discovery is restricted to, or all interfaces if unrestricted.
+// Devices which receive the request reply with a discovery packet.
+func (s *Serv) Probe(targets ...string) error {
+	if len(targets) == 0 && s.discovery.restricted {
+		targets = s.discovery.ifaces
+	} else if len(targets) == 0 {
+		ifaces, err := net.Interfaces()
+		if err != nil {
+			return err
+		}
+		for _, iface := range ifaces {
+			if iface.Flags&net.FlagUp != 0 && iface.Flags&net.FlagBroadcast != 0 && iface.Flags&net.FlagLoopback == 0 {
+				targets = append(targets, iface.Name)
+			}
+		}
+	}
+
+	var dests []net.IP
+	for _, t := range targets {
+		if _, subnet, err := net.ParseCIDR(t); err == nil {
+			if subnet.IP.To4() == nil {
+				return fmt.Errorf("cannot probe %s: discovery is IPv4 only", t)
+			}
+			dests = append(dests, broadcastAddr(subnet))
+			continue
+		}
+		iface, err := net.InterfaceByName(t)
+		if err != nil {
+			return fmt.Errorf("%q is not an interface or subnet: %v", t, err)
+		}
+		addrs, err := iface.Addrs()
+		if err != nil {
+			return err
+		}
+		for _, a := range addrs {
+			if subnet, ok := a.(*net.IPNet); ok && subnet.IP.To4() != nil {
+				dests = append(dests, broadcastAddr(subnet))
+			}
+		}
+	}
+
+	for _, ip := range dests {
+		if ip == nil {
+			continue
+		}
+		if _, err := s.discovery.conns[0].WriteToUDP(packet.ProbeRequest, &net.UDPAddr{IP: ip, Port: 10001}); err != nil {
+			return err
+		}
+		log.Printf("Sent discovery probe to %s\n", ip)
+	}
+	return nil
+}
+
+// broadcastAddr returns the broadcast address of an IPv4 subnet, or nil if the subnet is not IPv4.
+func broadcastAddr(subnet *net.IPNet) net.IP {
+	ip, mask := subnet.IP.To4(), subnet.Mask
+	if ip == nil || len(mask) != net.IPv4len {
+		return nil
+	}
+	out := make(net.IP, net.IPv4len)
+	for i := range ip {
+		out[i] = ip[i] | ^mask[i]
+	}
+	return out
+}
+
 func (s *Serv) httpMainloop() {
 	err := s.httpServ.ListenAndServe()
 	fmt.Printf("HTTP Server error: %s\n", err)
 }
 
-func (s *Serv) discoveryMainloop() {
-	defer close(s.DiscoveryPackets)
+func (s *Serv) discoveryMainloop(conn *net.UDPConn) {
+	buf, oob := make([]byte, 8192), make([]byte, 128)
 	for {
-		select {
-		case <-s.close:
-			return
-		default:
-			pkt, err := s.Recieve()
-			if err != nil {
+		n, oobn, _, addr, err := conn.ReadMsgUDP(buf, oob)
+		if err != nil {
+			select {
+			case <-s.close:
+			default:
 				log.Printf("Error reading Discovery packet: %s\n", err)
-				return
 			}
-			s.DiscoveryPackets <- pkt
+			return
+		}
+
+		if s.discovery.duplicate(addr, buf[:n]) {
+			continue
+		}
+		iface := s.discovery.ingressInterface(addr.IP, oob[:oobn])
+		if iface == "" && s.discovery.restricted {
+			continue // Not from a network we are listening on.
+		}
+
+		pkt, err := packet.DiscoveryDecode(addr, buf[:n])
+		if err == packet.ErrDiscoveryProbe || err == packet.ErrDiscoveryIncorrectHeader {
+			continue // Probe requests (including our own) and unrelated traffic.
+		}
+		if err != nil {
+			log.Printf("Error decoding Discovery packet from %s: %s\n", addr, err)
+			continue
 		}
+		pkt.Interface = iface
+		s.DiscoveryPackets <- pkt
 	}
 }
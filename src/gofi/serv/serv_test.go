@@ -0,0 +1,83 @@
+package serv
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestBroadcastAddr(t *testing.T) {
+	for in, expected := range map[string]string{
+		"192.168.1.0/24": "192.168.1.255",
+		"10.1.2.3/20":    "10.1.15.255",
+		"10.0.0.0/8":     "10.255.255.255",
+	} {
+		_, subnet, err := net.ParseCIDR(in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if out := broadcastAddr(subnet); out.String() != expected {
+			t.Errorf("broadcastAddr(%s) = %s, expected %s", in, out, expected)
+		}
+	}
+
+	_, v6, _ := net.ParseCIDR("fd00::/64")
+	if broadcastAddr(v6) != nil {
+		t.Error("Expected no broadcast address for IPv6 subnet")
+	}
+}
+
+func TestMatchInterface(t *testing.T) {
+	subnets := map[string][]*net.IPNet{}
+	for name, cidrs := range map[string][]string{
+		"eth0":      {"192.168.1.2/24", "fd00::2/64"},
+		"eth0.20":   {"10.20.0.1/16"},
+		"docker0":   {"172.17.0.1/16"},
+		"wireguard": {"10.20.5.1/24"},
+	} {
+		for _, c := range cidrs {
+			_, subnet, err := net.ParseCIDR(c)
+			if err != nil {
+				t.Fatal(err)
+			}
+			subnets[name] = append(subnets[name], subnet)
+		}
+	}
+	ifaces := []string{"eth0", "eth0.20", "docker0", "wireguard"}
+
+	for ip, expected := range map[string]string{
+		"192.168.1.20": "eth0",
+		"fd00::1234":   "eth0",
+		"10.20.5.7":    "eth0.20", // Listed before wireguard.
+		"172.17.0.9":   "docker0",
+		"192.168.2.1":  "",
+	} {
+		if out := matchInterface(net.ParseIP(ip), ifaces, subnets); out != expected {
+			t.Errorf("matchInterface(%s) = %q, expected %q", ip, out, expected)
+		}
+	}
+}
+
+func TestDiscoveryDuplicate(t *testing.T) {
+	l := &discoveryListener{seen: map[string]time.Time{}}
+	addr := &net.UDPAddr{IP: net.IPv4(192, 168, 1, 20), Port: 10001}
+	other := &net.UDPAddr{IP: net.IPv4(192, 168, 1, 21), Port: 10001}
+
+	if l.duplicate(addr, []byte{2, 6, 0, 0}) {
+		t.Error("First packet reported as a duplicate")
+	}
+	if !l.duplicate(addr, []byte{2, 6, 0, 0}) {
+		t.Error("Copy of packet not reported as a duplicate")
+	}
+	if l.duplicate(other, []byte{2, 6, 0, 0}) || l.duplicate(addr, []byte{2, 6, 0, 1}) {
+		t.Error("Distinct packet reported as a duplicate")
+	}
+
+	// Copies are forgotten after the window.
+	for k := range l.seen {
+		l.seen[k] = time.Now().Add(-2 * duplicateWindow)
+	}
+	if l.duplicate(addr, []byte{2, 6, 0, 0}) {
+		t.Error("Packet outside the window reported as a duplicate")
+	}
+}
@@ -0,0 +1,164 @@
+package unf
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"math"
+	"strconv"
+)
+
+// Document is a decoded BSON document. Values are one of: float64, string, Document, []interface{},
+// []byte (binary), bool, int32, int64, nil, or string (hex-encoded ObjectId).
+type Document map[string]interface{}
+
+// String returns the value of the named string field, or an empty string if the field
+// is not present or is not a string.
+func (d Document) String(name string) string {
+	s, _ := d[name].(string)
+	return s
+}
+
+// readDocuments decodes a stream of concatenated BSON documents.
+func readDocuments(b []byte) ([]Document, error) {
+	var out []Document
+	for len(b) > 0 {
+		doc, n, err := decodeDocument(b)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, doc)
+		b = b[n:]
+	}
+	return out, nil
+}
+
+// decodeDocument decodes the BSON document at the start of b, returning it and its length in bytes.
+func decodeDocument(b []byte) (Document, int, error) {
+	if len(b) < 5 {
+		return nil, 0, errors.New("bson: document too short")
+	}
+	l := int(int32(binary.LittleEndian.Uint32(b)))
+	if l < 5 || l > len(b) || b[l-1] != 0 {
+		return nil, 0, errors.New("bson: invalid document length " + strconv.Itoa(l))
+	}
+
+	doc := Document{}
+	body := b[4 : l-1]
+	for len(body) > 0 {
+		kind := body[0]
+		name, n, err := readCString(body[1:])
+		if err != nil {
+			return nil, 0, err
+		}
+		body = body[1+n:]
+
+		val, n, err := decodeValue(kind, body)
+		if err != nil {
+			return nil, 0, errors.New("bson: field " + strconv.Quote(name) + ": " + err.Error())
+		}
+		doc[name] = val
+		body = body[n:]
+	}
+	return doc, l, nil
+}
+
+// decodeValue decodes a value of the given type at the start of b, returning it and its length in bytes.
+func decodeValue(kind byte, b []byte) (interface{}, int, error) {
+	fixed := func(n int) error {
+		if len(b) < n {
+			return errors.New("value too short")
+		}
+		return nil
+	}
+
+	switch kind {
+	case 0x01: // double
+		if err := fixed(8); err != nil {
+			return nil, 0, err
+		}
+		return math.Float64frombits(binary.LittleEndian.Uint64(b)), 8, nil
+	case 0x02, 0x0D, 0x0E: // string, javascript, symbol
+		if err := fixed(4); err != nil {
+			return nil, 0, err
+		}
+		l := int(int32(binary.LittleEndian.Uint32(b)))
+		if l < 1 || 4+l > len(b) || b[3+l] != 0 {
+			return nil, 0, errors.New("invalid string length")
+		}
+		return string(b[4 : 3+l]), 4 + l, nil
+	case 0x03: // document
+		return decodeDocument(b)
+	case 0x04: // array
+		doc, n, err := decodeDocument(b)
+		if err != nil {
+			return nil, 0, err
+		}
+		arr := make([]interface{}, len(doc))
+		for k, v := range doc {
+			i, err := strconv.Atoi(k)
+			if err != nil || i < 0 || i >= len(arr) {
+				return nil, 0, errors.New("invalid array index " + strconv.Quote(k))
+			}
+			arr[i] = v
+		}
+		return arr, n, nil
+	case 0x05: // binary
+		if err := fixed(5); err != nil {
+			return nil, 0, err
+		}
+		l := int(int32(binary.LittleEndian.Uint32(b)))
+		if l < 0 || 5+l > len(b) {
+			return nil, 0, errors.New("invalid binary length")
+		}
+		return b[5 : 5+l], 5 + l, nil
+	case 0x06, 0x0A, 0x7F, 0xFF: // undefined, null, max key, min key
+		return nil, 0, nil
+	case 0x07: // ObjectId
+		if err := fixed(12); err != nil {
+			return nil, 0, err
+		}
+		return hex.EncodeToString(b[:12]), 12, nil
+	case 0x08: // bool
+		if err := fixed(1); err != nil {
+			return nil, 0, err
+		}
+		return b[0] != 0, 1, nil
+	case 0x09, 0x11, 0x12: // datetime, timestamp, int64
+		if err := fixed(8); err != nil {
+			return nil, 0, err
+		}
+		return int64(binary.LittleEndian.Uint64(b)), 8, nil
+	case 0x0B: // regex
+		pattern, n1, err := readCString(b)
+		if err != nil {
+			return nil, 0, err
+		}
+		_, n2, err := readCString(b[n1:])
+		if err != nil {
+			return nil, 0, err
+		}
+		return pattern, n1 + n2, nil
+	case 0x10: // int32
+		if err := fixed(4); err != nil {
+			return nil, 0, err
+		}
+		return int32(binary.LittleEndian.Uint32(b)), 4, nil
+	case 0x13: // decimal128
+		if err := fixed(16); err != nil {
+			return nil, 0, err
+		}
+		return b[:16], 16, nil
+	}
+	return nil, 0, errors.New("unsupported type 0x" + strconv.FormatUint(uint64(kind), 16))
+}
+
+// readCString returns the nul-terminated string at the start of b, and its length including the terminator.
+func readCString(b []byte) (string, int, error) {
+	i := bytes.IndexByte(b, 0)
+	if i < 0 {
+		return "", 0, errors.New("bson: unterminated string")
+	}
+	return string(b[:i]), i + 1, nil
+}
@@ -0,0 +1,129 @@
+// Package unf reads backups (.unf files) made by the official UniFi controller.
+package unf
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"crypto/aes"
+	"crypto/cipher"
+	"errors"
+	"io"
+	"io/ioutil"
+)
+
+// Backups are encrypted with a well-known key.
+var (
+	backupKey = []byte("bcyangkmluohmars")
+	backupIV  = []byte("ubntenterpriseap")
+)
+
+// Device is an adopted device recorded in a backup.
+type Device struct {
+	MAC     string
+	Model   string
+	Name    string
+	IP      string
+	AuthKey string // Hex-encoded inform key.
+
+	// SSH credentials of the site the device belongs to, if set.
+	SSHUser string
+	SSHPw   string
+}
+
+// Decrypt decrypts a backup, returning the zip archive it contains.
+func Decrypt(r io.Reader) ([]byte, error) {
+	d, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(d) == 0 || len(d)%aes.BlockSize != 0 {
+		return nil, errors.New("backup is not a multiple of the block size")
+	}
+	block, err := aes.NewCipher(backupKey)
+	if err != nil {
+		return nil, err
+	}
+	cipher.NewCBCDecrypter(block, backupIV).CryptBlocks(d, d)
+	if !bytes.HasPrefix(d, []byte("PK")) {
+		return nil, errors.New("backup did not decrypt to a zip archive")
+	}
+	return d, nil
+}
+
+// ReadDevices decrypts a backup, returning the adopted devices it contains.
+func ReadDevices(r io.Reader) ([]Device, error) {
+	archive, err := Decrypt(r)
+	if err != nil {
+		return nil, err
+	}
+	z, err := zip.NewReader(bytes.NewReader(archive), int64(len(archive)))
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range z.File {
+		if f.Name == "db.gz" {
+			return readDB(f)
+		}
+	}
+	return nil, errors.New("backup does not contain db.gz")
+}
+
+// readDB reads the database dump, which is a gzipped stream of BSON documents. Documents are
+// preceded by a {"__cmd": "select", "collection": <name>} document naming their collection.
+func readDB(f *zip.File) ([]Device, error) {
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	gz, err := gzip.NewReader(rc)
+	if err != nil {
+		return nil, err
+	}
+	defer gz.Close()
+	d, err := ioutil.ReadAll(gz)
+	if err != nil {
+		return nil, err
+	}
+	docs, err := readDocuments(d)
+	if err != nil {
+		return nil, err
+	}
+
+	var devices []Document
+	siteCreds := map[string]Document{}
+	var collection string
+	for _, doc := range docs {
+		if doc.String("__cmd") == "select" {
+			collection = doc.String("collection")
+			continue
+		}
+		switch {
+		case collection == "device":
+			devices = append(devices, doc)
+		case collection == "setting" && doc.String("key") == "mgmt":
+			siteCreds[doc.String("site_id")] = doc
+		}
+	}
+
+	out := make([]Device, 0, len(devices))
+	for _, doc := range devices {
+		if adopted, _ := doc["adopted"].(bool); !adopted || doc.String("x_authkey") == "" {
+			continue
+		}
+		dev := Device{
+			MAC:     doc.String("mac"),
+			Model:   doc.String("model"),
+			Name:    doc.String("name"),
+			IP:      doc.String("ip"),
+			AuthKey: doc.String("x_authkey"),
+		}
+		if creds, ok := siteCreds[doc.String("site_id")]; ok {
+			dev.SSHUser = creds.String("x_ssh_username")
+			dev.SSHPw = creds.String("x_ssh_password")
+		}
+		out = append(out, dev)
+	}
+	return out, nil
+}
@@ -0,0 +1,186 @@
+package unf
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"reflect"
+	"testing"
+)
+
+// elem is a BSON element, for constructing test documents in order.
+type elem struct {
+	name string
+	val  interface{}
+}
+
+func bsonDoc(elems ...elem) []byte {
+	var body bytes.Buffer
+	for _, e := range elems {
+		switch v := e.val.(type) {
+		case string:
+			body.WriteByte(0x02)
+			body.WriteString(e.name + "\x00")
+			binary.Write(&body, binary.LittleEndian, int32(len(v)+1))
+			body.WriteString(v + "\x00")
+		case bool:
+			body.WriteByte(0x08)
+			body.WriteString(e.name + "\x00")
+			if v {
+				body.WriteByte(1)
+			} else {
+				body.WriteByte(0)
+			}
+		case int32:
+			body.WriteByte(0x10)
+			body.WriteString(e.name + "\x00")
+			binary.Write(&body, binary.LittleEndian, v)
+		case [12]byte:
+			body.WriteByte(0x07)
+			body.WriteString(e.name + "\x00")
+			body.Write(v[:])
+		case []elem:
+			body.WriteByte(0x03)
+			body.WriteString(e.name + "\x00")
+			body.Write(bsonDoc(v...))
+		case []string:
+			body.WriteByte(0x04)
+			body.WriteString(e.name + "\x00")
+			var arr []elem
+			for i, s := range v {
+				arr = append(arr, elem{string('0' + byte(i)), s})
+			}
+			body.Write(bsonDoc(arr...))
+		default:
+			panic("unsupported test value")
+		}
+	}
+
+	out := make([]byte, 4, body.Len()+5)
+	binary.LittleEndian.PutUint32(out, uint32(body.Len()+5))
+	out = append(out, body.Bytes()...)
+	return append(out, 0)
+}
+
+// makeBackup constructs an encrypted backup containing the given database dump.
+func makeBackup(t *testing.T, db []byte) []byte {
+	var gz bytes.Buffer
+	w := gzip.NewWriter(&gz)
+	w.Write(db)
+	w.Close()
+
+	var archive bytes.Buffer
+	z := zip.NewWriter(&archive)
+	f, err := z.Create("version")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte("5.6.22\n"))
+	if f, err = z.Create("db.gz"); err != nil {
+		t.Fatal(err)
+	}
+	f.Write(gz.Bytes())
+	if err := z.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The official controller pads the archive with zeros.
+	d := archive.Bytes()
+	d = append(d, make([]byte, aes.BlockSize-len(d)%aes.BlockSize)...)
+	block, err := aes.NewCipher(backupKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cipher.NewCBCEncrypter(block, backupIV).CryptBlocks(d, d)
+	return d
+}
+
+func TestReadDevices(t *testing.T) {
+	var db bytes.Buffer
+	db.Write(bsonDoc(elem{"__cmd", "select"}, elem{"collection", "site"}))
+	db.Write(bsonDoc(elem{"_id", [12]byte{0x5a, 1}}, elem{"name", "default"}))
+	db.Write(bsonDoc(elem{"__cmd", "select"}, elem{"collection", "setting"}))
+	db.Write(bsonDoc(elem{"key", "mgmt"}, elem{"site_id", "5a01"}, elem{"x_ssh_username", "admin"}, elem{"x_ssh_password", "hunter2"}))
+	db.Write(bsonDoc(elem{"key", "connectivity"}, elem{"site_id", "5a01"}, elem{"x_ssh_password", "not this"}))
+	db.Write(bsonDoc(elem{"__cmd", "select"}, elem{"collection", "device"}))
+	db.Write(bsonDoc(
+		elem{"_id", [12]byte{0x5b, 2}},
+		elem{"mac", "f0:9f:c2:aa:bb:cc"},
+		elem{"model", "U7LR"},
+		elem{"name", "Lobby"},
+		elem{"ip", "192.168.1.20"},
+		elem{"adopted", true},
+		elem{"x_authkey", "41d6529fd555fbb1bdeeafeb995510fa"},
+		elem{"cfgversion", "f1bb359840b519a4"},
+		elem{"site_id", "5a01"},
+		elem{"radio_table", []elem{{"radio", "ng"}, {"channel", int32(6)}}},
+		elem{"ethernet_overrides", []string{"eth0", "eth1"}},
+	))
+	db.Write(bsonDoc(elem{"mac", "80:2a:a8:11:22:33"}, elem{"model", "U7PG2"}, elem{"adopted", false}))
+
+	devices, err := ReadDevices(bytes.NewReader(makeBackup(t, db.Bytes())))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []Device{
+		{
+			MAC:     "f0:9f:c2:aa:bb:cc",
+			Model:   "U7LR",
+			Name:    "Lobby",
+			IP:      "192.168.1.20",
+			AuthKey: "41d6529fd555fbb1bdeeafeb995510fa",
+			SSHUser: "admin",
+			SSHPw:   "hunter2",
+		},
+	}
+	if !reflect.DeepEqual(devices, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, devices)
+	}
+}
+
+func TestDecodeDocument(t *testing.T) {
+	doc, n, err := decodeDocument(bsonDoc(
+		elem{"s", "str"},
+		elem{"nested", []elem{{"n", int32(-3)}}},
+		elem{"arr", []string{"a", "b"}},
+		elem{"b", true},
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := Document{
+		"s":      "str",
+		"nested": Document{"n": int32(-3)},
+		"arr":    []interface{}{"a", "b"},
+		"b":      true,
+	}
+	if !reflect.DeepEqual(doc, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, doc)
+	}
+	if n != len(bsonDoc(elem{"s", "str"}, elem{"nested", []elem{{"n", int32(-3)}}}, elem{"arr", []string{"a", "b"}}, elem{"b", true})) {
+		t.Errorf("Unexpected length %d", n)
+	}
+
+	for _, in := range [][]byte{
+		{},
+		{5, 0, 0, 0},
+		{6, 0, 0, 0, 0x02, 0},             // truncated element
+		{12, 0, 0, 0, 0x42, 'x', 0, 0, 0}, // unknown type
+	} {
+		if _, _, err := decodeDocument(in); err == nil {
+			t.Errorf("Expected error decoding %x", in)
+		}
+	}
+}
+
+func TestDecryptRejectsGarbage(t *testing.T) {
+	if _, err := Decrypt(bytes.NewReader(make([]byte, 32))); err == nil {
+		t.Error("Expected error for non-backup")
+	}
+	if _, err := Decrypt(bytes.NewReader(make([]byte, 7))); err == nil {
+		t.Error("Expected error for truncated backup")
+	}
+}